@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"vuln-ai-backend/modules"
+)
+
+// Exit codes of the scan command.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitHighRisk = 2 // scan finished and reported High-priority results
+)
+
+const scanUsage = `Usage:
+  %[1]s scan subdomains [flags] [subdomain ...]
+  %[1]s scan urls [flags] [url ...]
+
+Runs an analysis without the web UI. Targets are read from -f (use "-" for
+stdin) and from the remaining arguments. Progress is printed to stderr.
+The exit code is 2 when any result has High priority.
+
+`
+
+// runScanCommand implements the headless "scan" subcommand and returns the
+// process exit code.
+func runScanCommand(args []string) int {
+	name := filepath.Base(os.Args[0])
+	if len(args) == 0 || (args[0] != "subdomains" && args[0] != "urls") {
+		fmt.Fprintf(os.Stderr, scanUsage, name)
+		return exitError
+	}
+	kind := args[0]
+
+	fs := flag.NewFlagSet(name+" scan "+kind, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), scanUsage, name)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", os.Getenv("VULN_AI_CONFIG"), "path to a YAML config file for scan defaults")
+	inputFile := fs.String("f", "", "file with one target per line (\"-\" for stdin)")
+	outputFile := fs.String("o", "", "output file (default stdout)")
+	format := fs.String("format", "", "output format: json, jsonl or text (default from -o extension, else json)")
+	rps := fs.Float64("rps", 0, "requests per second across the whole scan (default from config)")
+	concurrency := fs.Int("concurrency", 0, "targets analysed in parallel (default from config)")
+	timeout := fs.Duration("timeout", 0, "abort the scan after this duration, e.g. 30m")
+	quiet := fs.Bool("quiet", false, "do not print progress")
+	deepCrawl := fs.Bool("deep-crawl", false, "subdomains: collect headers, technologies and endpoints")
+	portScan := fs.Bool("port-scan", false, "subdomains: scan common TCP ports")
+	udpScan := fs.Bool("udp", false, "subdomains: also probe common UDP services (DNS, NTP, SNMP, SSDP, memcached, TFTP) in the port scan")
+	ports := fs.String("ports", "", "subdomains: ports to scan: top-100, top-1000, web, databases, full or ranges such as 1-1024,8080 (default from config)")
+	portConcurrency := fs.Int("port-concurrency", 0, "subdomains: ports dialled at once per host IP (default from config)")
+	perHostRPS := fs.Float64("per-host-rps", 0, "subdomains: requests per second per target IP")
+	profile := fs.String("profile", "", "subdomains: scan profile from the config")
+	domain := fs.String("domain", "", "subdomains: enumerate subdomains of this root domain first")
+	sources := fs.String("sources", "", "subdomains: comma-separated enumeration sources: ct, zone, bruteforce, history")
+	resolvers := fs.String("resolvers", "", "subdomains: comma-separated DNS servers for enumeration and record lookups")
+	wordlist := fs.String("wordlist", "", "subdomains: brute-force wordlist (default bundled list)")
+	zoneFile := fs.String("zone-file", "", "subdomains: DNS zone file to extract names from")
+	crawlDepth := fs.Int("crawl-depth", -1, "subdomains: links followed from the landing page in deep crawl (default from config)")
+	crawlPages := fs.Int("crawl-pages", 0, "subdomains: pages fetched per subdomain in deep crawl (default from config)")
+	crawlScope := fs.String("crawl-scope", "", "subdomains: deep crawl scope, host or domain (default from config)")
+	var crawlAllow, crawlDeny stringList
+	fs.Var(&crawlAllow, "crawl-allow", "subdomains: regular expression crawled URLs must match (repeatable)")
+	fs.Var(&crawlDeny, "crawl-deny", "subdomains: regular expression excluded from the crawl (repeatable)")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return exitError
+	}
+
+	cfg, err := modules.LoadConfig(*configPath)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return exitError
+	}
+	modules.SetConfig(cfg)
+
+	targets, err := readTargets(*inputFile, positional)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading targets: %v\n", err)
+		return exitError
+	}
+	if len(targets) == 0 && (kind != "subdomains" || *domain == "") {
+		fmt.Fprintln(os.Stderr, "No targets provided, use -f or pass them as arguments.")
+		return exitError
+	}
+
+	outFormat := *format
+	if outFormat == "" {
+		outFormat = formatFromPath(*outputFile)
+	}
+	if outFormat != "json" && outFormat != "jsonl" && outFormat != "text" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q, expected json, jsonl or text.\n", outFormat)
+		return exitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	progress := func(processed, total int, message string) {
+		if !*quiet {
+			fmt.Fprintf(os.Stderr, "[%3d%%] %s\n", (processed*100)/total, message)
+		}
+	}
+	rate := formatFloat(*rps)
+
+	var results []reportable
+	switch kind {
+	case "subdomains":
+		req := modules.SubdomainAnalysisRequest{
+			Subdomains:        targets,
+			RequestsPerSecond: rate,
+			Concurrency:       formatInt(*concurrency),
+			PerHostRPS:        formatFloat(*perHostRPS),
+			Profile:           *profile,
+			RootDomain:        *domain,
+			Resolvers:         *resolvers,
+			CrawlPages:        formatInt(*crawlPages),
+			CrawlScope:        *crawlScope,
+			CrawlAllow:        crawlAllow,
+			CrawlDeny:         crawlDeny,
+			Ports:             *ports,
+			PortConcurrency:   formatInt(*portConcurrency),
+		}
+		if *crawlDepth >= 0 {
+			req.CrawlDepth = strconv.Itoa(*crawlDepth)
+		}
+		if *domain != "" {
+			opts := modules.EnumOptions{Sources: modules.SplitList(*sources), Resolvers: cfg.Scan.Resolvers}
+			if *resolvers != "" {
+				opts.Resolvers = modules.SplitList(*resolvers)
+			}
+			if opts.Wordlist, err = readOptionalFile(*wordlist); err == nil {
+				opts.ZoneFile, err = readOptionalFile(*zoneFile)
+			}
+			if err == nil {
+				req.Enumerators, err = modules.BuildEnumSources(opts)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return exitError
+			}
+		}
+		if *deepCrawl {
+			req.IsDeepCrawl = "true"
+		}
+		if *portScan {
+			req.IsPortScan = "true"
+		}
+		if *udpScan {
+			req.IsUDPScan = "true"
+		}
+		scanned, err := modules.RunSubdomainAnalysis(ctx, req, progress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		for _, r := range scanned {
+			results = append(results, subdomainReport(r))
+		}
+	case "urls":
+		req := modules.URLAnalysisRequest{
+			URLs:              targets,
+			RequestsPerSecond: rate,
+			Concurrency:       formatInt(*concurrency),
+		}
+		for _, r := range modules.RunURLAnalysis(ctx, req, progress) {
+			results = append(results, urlReport(r))
+		}
+	}
+
+	if err := writeResults(*outputFile, outFormat, results); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+		return exitError
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Scan aborted after %d/%d targets: %v\n", len(results), len(targets), context.Cause(ctx))
+		return exitError
+	}
+
+	high := 0
+	for _, r := range results {
+		if r.priority() == "High" {
+			high++
+		}
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "Done: %d results, %d High priority.\n", len(results), high)
+	}
+	if high > 0 {
+		return exitHighRisk
+	}
+	return exitOK
+}
+
+// reportable is a scan result that can be written in every output format.
+type reportable interface {
+	priority() string
+	text() string
+}
+
+type subdomainReport modules.AnalysisResult
+
+func (r subdomainReport) priority() string { return r.Priority }
+func (r subdomainReport) text() string     { return r.Report }
+
+type urlReport modules.URLAnalysisResult
+
+func (r urlReport) priority() string { return r.Priority }
+func (r urlReport) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "URL: %s\n", r.URL)
+	fmt.Fprintf(&b, "Reachable: %v\n", r.IsReachable)
+	fmt.Fprintf(&b, "Priority: %s\n", r.Priority)
+	if !r.IsReachable {
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Status: %d\n", r.StatusCode)
+	fmt.Fprintf(&b, "Content-Length: %d\n", r.ContentLength)
+	if len(r.Findings) > 0 {
+		b.WriteString("\nFindings:\n")
+		for _, f := range r.Findings {
+			fmt.Fprintf(&b, "- [%s] %s (%s confidence)\n", f.Severity, f.Title, f.Confidence)
+			fmt.Fprintf(&b, "  Location: %s\n", f.Location)
+			if f.Evidence != "" {
+				fmt.Fprintf(&b, "  Evidence: %s\n", f.Evidence)
+			}
+		}
+	}
+	if r.Headers != "" {
+		b.WriteString("\nHeaders:\n" + r.Headers)
+	}
+	return b.String()
+}
+
+func writeResults(path, format string, results []reportable) error {
+	var w io.Writer = os.Stdout
+	if path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(bw)
+		enc.SetIndent("", "  ")
+		if results == nil {
+			results = []reportable{}
+		}
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	case "jsonl":
+		enc := json.NewEncoder(bw)
+		for _, r := range results {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+	case "text":
+		for i, r := range results {
+			if i > 0 {
+				bw.WriteString("\n" + strings.Repeat("=", 60) + "\n\n")
+			}
+			bw.WriteString(r.text())
+		}
+	}
+	return bw.Flush()
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseInterspersed parses fs allowing flags after positional arguments, so
+// that "scan urls https://example.com -o out.json" works as expected.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// readTargets collects non-empty lines from path (or stdin for "-") followed
+// by the extra command-line arguments.
+func readTargets(path string, extra []string) ([]string, error) {
+	var targets []string
+	if path != "" {
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				targets = append(targets, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return append(targets, extra...), nil
+}
+
+func readOptionalFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(path)
+}
+
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	case ".txt":
+		return "text"
+	default:
+		return "json"
+	}
+}
+
+func formatFloat(f float64) string {
+	if f <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatInt(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
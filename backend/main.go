@@ -3,10 +3,19 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 	"vuln-ai-backend/modules"
 
@@ -18,46 +27,126 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+var allowedOrigins []string
+
+// upgrader accepts WebSocket connections from the UI served by this server
+// and from the configured origins. Clients that send no Origin are not
+// browsers and are left to authentication.
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		return true
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, allowed := range allowedOrigins {
+			if strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+		return false
 	},
 }
 
 func main() {
-	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
-
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"POST", "GET", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-
-	api := router.Group("/api/v1")
+	if len(os.Args) > 1 && os.Args[1] == "scan" {
+		os.Exit(runScanCommand(os.Args[2:]))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	modules.SetConfig(cfg)
+	allowedOrigins = cfg.Server.AllowedOrigins
+
+	store, err := modules.OpenBoltStore(cfg.Storage.Path)
+	if err != nil {
+		log.Fatalf("Failed to open job store %s: %v", cfg.Storage.Path, err)
+	}
+	defer store.Close()
+	modules.SetStore(store)
+	if err := modules.EnsureAdmin(); err != nil {
+		log.Fatalf("Failed to create the admin account: %v", err)
+	}
+	if err := modules.StartScheduler(); err != nil {
+		log.Fatalf("Failed to load schedules: %v", err)
+	}
+
+	gin.SetMode(cfg.Server.Mode)
+	router := gin.New()
+	// Client IPs, used to rate limit logins, come from the peer address
+	// unless it is a configured reverse proxy.
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	// TokenFromQuery runs first so that tokens never reach the request log.
+	router.Use(modules.TokenFromQuery(), gin.Logger(), gin.Recovery())
+
+	// The UI served below is same-origin; CORS is only needed for a
+	// frontend hosted elsewhere.
+	if len(cfg.Server.AllowedOrigins) > 0 {
+		router.Use(cors.New(cors.Config{
+			AllowOrigins:     cfg.Server.AllowedOrigins,
+			AllowMethods:     []string{"POST", "GET", "PUT", "DELETE", "OPTIONS"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization"},
+			AllowCredentials: true,
+			MaxAge:           12 * time.Hour,
+		}))
+	}
+
+	index := filepath.Join(cfg.Server.FrontendDir, "index.html")
+	if _, err := os.Stat(index); err == nil {
+		router.StaticFile("/", index)
+	} else {
+		log.Printf("Frontend not found at %s, serving the API only", index)
+	}
+
+	router.POST("/api/v1/auth/login", modules.HandleLogin)
+
+	api := router.Group("/api/v1", modules.RequireAuth())
 	{
+		api.POST("/auth/logout", modules.HandleLogout)
+		api.GET("/auth/me", modules.HandleCurrentUser)
+		api.POST("/auth/password", modules.HandleChangePassword)
+
+		users := api.Group("/users", modules.RequireAdmin())
+		users.GET("", modules.HandleListUsers)
+		users.POST("", modules.HandleCreateUser)
+		users.PUT("/:username", modules.HandleUpdateUser)
+		users.DELETE("/:username", modules.HandleDeleteUser)
+
 		api.POST("/subdomains/analyze", modules.HandleSubdomainAnalysis)
 		api.POST("/urls/analyze", modules.HandleURLAnalysis)
+		api.POST("/js/analyze", modules.HandleJSAnalysis)
 		api.POST("/ai/passive-scan", modules.HandlePassiveAIScan)
 		api.POST("/ai/active-scan", modules.HandleActiveAIScan)
 		api.POST("/ai/custom-scan", modules.HandleCustomAIScan)
 		api.GET("/ws/progress/:jobID", handleProgressUpdates)
-		api.POST("/jobs/:jobID/pause", func(c *gin.Context) {
-			jobID := c.Param("jobID")
-			modules.SetJobState(jobID, "paused")
-			c.JSON(http.StatusOK, gin.H{"status": "paused"})
-		})
-		api.POST("/jobs/:jobID/resume", func(c *gin.Context) {
-			jobID := c.Param("jobID")
-			modules.SetJobState(jobID, "running")
-			c.JSON(http.StatusOK, gin.H{"status": "running"})
-		})
+		api.GET("/jobs", modules.HandleListJobs)
+		api.GET("/jobs/:jobID", modules.HandleGetJob)
+		api.POST("/jobs/:jobID/pause", modules.HandlePauseJob)
+		api.POST("/jobs/:jobID/resume", modules.HandleResumeJob)
+		api.POST("/jobs/:jobID/cancel", modules.HandleCancelJob)
+		api.DELETE("/jobs/:jobID", modules.HandleDeleteJob)
+		api.GET("/jobs/:jobID/diff/:otherJobID", modules.HandleJobDiff)
+		api.GET("/jobs/:jobID/events", modules.HandleJobEvents)
+		api.GET("/jobs/:jobID/progress", modules.HandleJobProgress)
+		api.GET("/schedules", modules.HandleListSchedules)
+		api.POST("/schedules", modules.HandleCreateSchedule)
+		api.GET("/schedules/:scheduleID", modules.HandleGetSchedule)
+		api.PUT("/schedules/:scheduleID", modules.HandleUpdateSchedule)
+		api.DELETE("/schedules/:scheduleID", modules.HandleDeleteSchedule)
+		api.POST("/schedules/:scheduleID/run", modules.HandleRunSchedule)
 		api.GET("/subdomains/export/:jobID", func(c *gin.Context) {
-			jobID := c.Param("jobID")
+			job, ok := modules.LookupJob(c)
+			if !ok {
+				return
+			}
 			deepcrawl := c.Query("deepcrawl") == "true"
-			results, ok := modules.GetSubdomainResults(jobID)
+			results, ok := modules.GetSubdomainResults(job.ID)
 			if !ok {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Results not found for this jobID"})
 				return
@@ -125,14 +214,97 @@ func main() {
 		})
 	}
 
-	log.Println("VULN_AI Go Backend (Final) starting on http://localhost:8080")
-	if err := router.Run(":8080"); err != nil {
-		log.Fatalf("Failed to run server: %v", err)
+	srv := &http.Server{Addr: cfg.Server.Listen, Handler: router}
+	go func() {
+		var err error
+		if cfg.Server.TLSCert != "" {
+			log.Printf("VULN_AI Go Backend (Final) starting on https://%s", cfg.Server.Listen)
+			err = srv.ListenAndServeTLS(cfg.Server.TLSCert, cfg.Server.TLSKey)
+		} else {
+			log.Printf("VULN_AI Go Backend (Final) starting on http://%s", cfg.Server.Listen)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to run server: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Println("Shutting down, aborting running jobs...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
 	}
+	modules.StopScheduler()
+	modules.ShutdownJobs()
+	modules.WaitNotifications(10 * time.Second)
 }
 
+// loadConfig resolves the server configuration from the optional config file,
+// VULN_AI_* environment variables and command-line flags, in that order.
+func loadConfig() (modules.Config, error) {
+	configPath := flag.String("config", os.Getenv("VULN_AI_CONFIG"), "path to a YAML config file")
+	listen := flag.String("listen", "", "listen address, e.g. :8080")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	origins := flag.String("allowed-origins", "", "comma-separated list of allowed CORS origins")
+	mode := flag.String("mode", "", "server mode: release, debug or test")
+	storagePath := flag.String("db", "", "path of the job store database")
+	flag.Parse()
+
+	cfg, err := modules.LoadConfig(*configPath)
+	if err != nil {
+		return cfg, err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Server.Listen = *listen
+		case "tls-cert":
+			cfg.Server.TLSCert = *tlsCert
+		case "tls-key":
+			cfg.Server.TLSKey = *tlsKey
+		case "allowed-origins":
+			cfg.Server.AllowedOrigins = modules.SplitList(*origins)
+		case "mode":
+			cfg.Server.Mode = *mode
+		case "db":
+			cfg.Storage.Path = *storagePath
+		}
+	})
+	return cfg, cfg.Validate()
+}
+
+// WebSocket heartbeats: the server pings every wsPingPeriod and drops a
+// client that has not answered within wsPongWait.
+const (
+	wsPingPeriod = 25 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsWriteWait  = 10 * time.Second
+)
+
+// handleProgressUpdates streams the events of a job. Clients that reconnect
+// pass the seq of the last event they received as ?since=N. Browsers cannot
+// set headers on WebSocket requests and authenticate with ?access_token=.
 func handleProgressUpdates(c *gin.Context) {
-	jobID := c.Param("jobID")
+	job, ok := modules.LookupJob(c)
+	if !ok {
+		return
+	}
+	jobID := job.ID
+	since := 0
+	if v := c.Query("since"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since parameter"})
+			return
+		}
+		since = n
+	}
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed for job %s: %v", jobID, err)
@@ -140,9 +312,30 @@ func handleProgressUpdates(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	log.Printf("WebSocket connected for job %s", jobID)
-	modules.RegisterClient(jobID, conn)
-	defer modules.UnregisterClient(jobID, conn)
+	log.Printf("WebSocket connected for job %s (since %d)", jobID, since)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	unsubscribe := modules.SubscribeClient(jobID, since, conn)
+	defer unsubscribe()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(wsPingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
 
 	for {
 		if _, _, err := conn.ReadMessage(); err != nil {
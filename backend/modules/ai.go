@@ -61,7 +61,7 @@ func HandlePassiveAIScan(c *gin.Context) {
 		req.Headers,
 	)
 
-	result := callAIProvider(req.AIProvider, req.APIKey, prompt)
+	result := callAIProvider(c.Request.Context(), req.AIProvider, req.APIKey, prompt)
 	c.JSON(http.StatusOK, gin.H{"summary": result})
 }
 
@@ -84,7 +84,7 @@ func HandleActiveAIScan(c *gin.Context) {
 		strings.Join(req.Endpoints, ", "),
 	)
 
-	result := callAIProvider(req.AIProvider, req.APIKey, prompt)
+	result := callAIProvider(c.Request.Context(), req.AIProvider, req.APIKey, prompt)
 	c.JSON(http.StatusOK, gin.H{"summary": result})
 }
 
@@ -112,19 +112,24 @@ func HandleCustomAIScan(c *gin.Context) {
 		req.CustomPrompt,
 	)
 
-	result := callAIProvider(req.AIProvider, req.APIKey, prompt)
+	result := callAIProvider(c.Request.Context(), req.AIProvider, req.APIKey, prompt)
 	c.JSON(http.StatusOK, gin.H{"summary": result})
 }
 
 // --- Core AI Interaction (Simulation) ---
 
-func callAIProvider(provider, apiKey, prompt string) string {
+func callAIProvider(ctx context.Context, provider, apiKey, prompt string) string {
+	if provider == "" {
+		provider = cfg.AI.DefaultProvider
+	}
+	providerCfg := cfg.AI.Providers[provider]
+	if apiKey == "" {
+		apiKey = providerCfg.APIKey
+	}
 	if apiKey == "" {
 		return "AI analysis disabled. Please provide an API key."
 	}
 
-	ctx := context.Background()
-
 	switch provider {
 	case "google":
 		client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
@@ -134,7 +139,7 @@ func callAIProvider(provider, apiKey, prompt string) string {
 		}
 		defer client.Close()
 
-		model := client.GenerativeModel("gemini-1.5-flash-latest")
+		model := client.GenerativeModel(providerCfg.Model)
 		resp, err := model.GenerateContent(ctx, genai.Text(prompt))
 		if err != nil {
 			log.Printf("Error calling Google AI: %v", err)
@@ -149,11 +154,15 @@ func callAIProvider(provider, apiKey, prompt string) string {
 		return "Error: Received an empty or invalid response from Google AI."
 
 	case "openai":
-		client := openai.NewClient(apiKey)
+		config := openai.DefaultConfig(apiKey)
+		if providerCfg.BaseURL != "" {
+			config.BaseURL = providerCfg.BaseURL
+		}
+		client := openai.NewClientWithConfig(config)
 		resp, err := client.CreateChatCompletion(
 			ctx,
 			openai.ChatCompletionRequest{
-				Model:    openai.GPT3Dot5Turbo,
+				Model:    providerCfg.Model,
 				Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}},
 			},
 		)
@@ -168,9 +177,9 @@ func callAIProvider(provider, apiKey, prompt string) string {
 
 	case "deepseek":
 		config := openai.DefaultConfig(apiKey)
-		config.BaseURL = "https://api.deepseek.com"
+		config.BaseURL = providerCfg.BaseURL
 		client := openai.NewClientWithConfig(config)
-		resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{Model: "deepseek-chat", Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}}})
+		resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{Model: providerCfg.Model, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}}})
 		if err != nil {
 			log.Printf("Error calling Deepseek: %v", err)
 			return fmt.Sprintf("Error from Deepseek: %v", err)
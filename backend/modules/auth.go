@@ -0,0 +1,496 @@
+package modules
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/time/rate"
+)
+
+// User roles. Admins see and manage every job, schedule and account; users
+// only their own jobs and schedules.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// User is an account of the web UI and API. The password hash is never
+// returned by the API.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash,omitempty"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Session is a login. It is stored under the SHA-256 of its token so that a
+// copy of the database does not hand out valid tokens.
+type Session struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt ignores the rest
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// userKey and sessionKey are the gin context keys of the authenticated
+// user and the ID of their session.
+const (
+	userKey    = "user"
+	sessionKey = "session"
+)
+
+// loginLimiters slow down password guessing: each client IP gets a burst
+// of attempts, then one every few seconds. The IP is the peer address
+// unless the request came through one of server.trustedProxies.
+var (
+	loginLimiters   = make(map[string]*loginLimiter)
+	loginLimitersMu sync.Mutex
+	loginSweptAt    time.Time
+)
+
+type loginLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// loginLimiterIdle is how long a client's limiter is kept after its last
+// attempt. It refills completely in less time, so forgetting it afterwards
+// gives the client nothing it would not have had anyway.
+const loginLimiterIdle = time.Minute
+
+func loginAllowed(ip string) bool {
+	loginLimitersMu.Lock()
+	defer loginLimitersMu.Unlock()
+	now := time.Now()
+	if now.Sub(loginSweptAt) > loginLimiterIdle {
+		for k, l := range loginLimiters {
+			if now.Sub(l.lastSeen) > loginLimiterIdle {
+				delete(loginLimiters, k)
+			}
+		}
+		loginSweptAt = now
+	}
+	l, ok := loginLimiters[ip]
+	if !ok {
+		l = &loginLimiter{limiter: rate.NewLimiter(rate.Every(5*time.Second), 10)}
+		loginLimiters[ip] = l
+	}
+	l.lastSeen = now
+	return l.limiter.AllowN(now, 1)
+}
+
+// dummyHash is compared against when the username does not exist, so that
+// the response time does not reveal which accounts exist.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", errors.New("the password must have at least 8 characters")
+	}
+	if len(password) > maxPasswordLength {
+		return "", errors.New("the password must have at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleUser
+}
+
+// public returns u without its password hash.
+func (u User) public() User {
+	u.PasswordHash = ""
+	return u
+}
+
+// EnsureAdmin creates the admin account of the config if the store has no
+// users yet. Without a configured password a random one is generated and
+// logged once.
+func EnsureAdmin() error {
+	users, err := getStore().ListUsers()
+	if err != nil || len(users) > 0 {
+		return err
+	}
+	password := cfg.Auth.AdminPassword
+	generated := password == ""
+	if generated {
+		password = newToken()[:20]
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	admin := User{Username: cfg.Auth.AdminUsername, PasswordHash: hash, Role: RoleAdmin, CreatedAt: time.Now().UTC()}
+	if err := getStore().SaveUser(admin); err != nil {
+		return err
+	}
+	if generated {
+		log.Printf("Created admin account %q with password %s - change it after logging in", admin.Username, password)
+	} else {
+		log.Printf("Created admin account %q", admin.Username)
+	}
+	return nil
+}
+
+// newToken returns a random session token.
+func newToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // the system random source never fails on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenFromQuery moves an access_token query parameter into the
+// Authorization header. Browsers cannot set headers on WebSocket and
+// EventSource requests, so those pass the token in the URL; removing it
+// before the request logger runs keeps it out of the logs. It must be
+// registered before the logger.
+func TokenFromQuery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		if token := q.Get("access_token"); token != "" {
+			if c.GetHeader("Authorization") == "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+			q.Del("access_token")
+			c.Request.URL.RawQuery = q.Encode()
+		}
+		c.Next()
+	}
+}
+
+// RequireAuth rejects requests without a valid session token, given as
+// "Authorization: Bearer <token>", and makes the user available to the
+// handlers.
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		user, err := authenticate(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+			return
+		}
+		c.Set(userKey, user)
+		c.Set(sessionKey, sessionID(token))
+		c.Next()
+	}
+}
+
+// RequireAdmin must follow RequireAuth.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if currentUser(c).Role != RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func authenticate(token string) (User, error) {
+	s := getStore()
+	session, err := s.GetSession(sessionID(token))
+	if err != nil {
+		return User{}, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		s.DeleteSession(session.ID)
+		return User{}, ErrSessionNotFound
+	}
+	return s.GetUser(session.Username) // fails once the account is deleted
+}
+
+// currentUser returns the user authenticated by RequireAuth.
+func currentUser(c *gin.Context) User {
+	if v, ok := c.Get(userKey); ok {
+		return v.(User)
+	}
+	return User{}
+}
+
+// canAccess reports whether the user of the request may see a job or
+// schedule of owner. Jobs from before accounts existed have no owner and
+// are only visible to admins.
+func canAccess(c *gin.Context, owner string) bool {
+	return userCanAccess(currentUser(c), owner)
+}
+
+// userCanAccess is canAccess for a user outside a request, e.g. the owner
+// of a schedule.
+func userCanAccess(u User, owner string) bool {
+	return u.Role == RoleAdmin || (owner != "" && owner == u.Username)
+}
+
+// --- API Handlers for Accounts ---
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// HandleLogin checks a username and password and returns a session token.
+func HandleLogin(c *gin.Context) {
+	var req loginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !loginAllowed(c.ClientIP()) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many login attempts, try again later"})
+		return
+	}
+	user, err := getStore().GetUser(req.Username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		log.Printf("Error loading user %s: %v", req.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not log in"})
+		return
+	}
+	hash := []byte(user.PasswordHash)
+	if err != nil {
+		hash = dummyHash()
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	token := newToken()
+	now := time.Now().UTC()
+	session := Session{ID: sessionID(token), Username: user.Username, CreatedAt: now, ExpiresAt: now.Add(cfg.Auth.SessionTTL)}
+	if err := getStore().SaveSession(session); err != nil {
+		log.Printf("Error saving session of %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not log in"})
+		return
+	}
+	log.Printf("User %s logged in", user.Username)
+	c.JSON(http.StatusOK, gin.H{"token": token, "expiresAt": session.ExpiresAt, "user": user.public()})
+}
+
+func HandleLogout(c *gin.Context) {
+	if err := getStore().DeleteSession(c.GetString(sessionKey)); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		log.Printf("Error deleting session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "logged out"})
+}
+
+func HandleCurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, currentUser(c).public())
+}
+
+type passwordChangeRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func HandleChangePassword(c *gin.Context) {
+	var req passwordChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	user := currentUser(c)
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "The current password is wrong"})
+		return
+	}
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user.PasswordHash = hash
+	if err := getStore().SaveUser(user); err != nil {
+		log.Printf("Error saving user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not change password"})
+		return
+	}
+	if !revokeSessions(c, user.Username, c.GetString(sessionKey)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "password changed"})
+}
+
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"` // default user
+}
+
+func HandleListUsers(c *gin.Context) {
+	users, err := getStore().ListUsers()
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list users"})
+		return
+	}
+	for i := range users {
+		users[i] = users[i].public()
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+func HandleCreateUser(c *gin.Context) {
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usernames are 1-64 letters, digits and . _ @ -"})
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleUser
+	}
+	if !validRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role, expected admin or user"})
+		return
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := User{Username: req.Username, PasswordHash: hash, Role: req.Role, CreatedAt: time.Now().UTC()}
+	err = getStore().CreateUser(user)
+	if errors.Is(err, ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create user"})
+		return
+	}
+	c.JSON(http.StatusOK, user.public())
+}
+
+// HandleUpdateUser sets the password and/or role of an account.
+func HandleUpdateUser(c *gin.Context) {
+	user, ok := lookupUser(c)
+	if !ok {
+		return
+	}
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Role != "" {
+		if !validRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role, expected admin or user"})
+			return
+		}
+		if user.Username == currentUser(c).Username && req.Role != RoleAdmin {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot remove your own administrator role"})
+			return
+		}
+		user.Role = req.Role
+	}
+	if req.Password != "" {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.PasswordHash = hash
+	}
+	if err := getStore().SaveUser(user); err != nil {
+		log.Printf("Error saving user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update user"})
+		return
+	}
+	if req.Password != "" {
+		// A reset password ends every session; an admin resetting their
+		// own keeps the one making the request.
+		keep := ""
+		if user.Username == currentUser(c).Username {
+			keep = c.GetString(sessionKey)
+		}
+		if !revokeSessions(c, user.Username, keep) {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, user.public())
+}
+
+// HandleDeleteUser removes an account. Its sessions stop working; its jobs
+// and schedules are kept and stay visible to admins.
+func HandleDeleteUser(c *gin.Context) {
+	user, ok := lookupUser(c)
+	if !ok {
+		return
+	}
+	if user.Username == currentUser(c).Username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account"})
+		return
+	}
+	if err := getStore().DeleteUser(user.Username); err != nil && !errors.Is(err, ErrUserNotFound) {
+		log.Printf("Error deleting user %s: %v", user.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete user"})
+		return
+	}
+	if err := getStore().DeleteUserSessions(user.Username, ""); err != nil {
+		log.Printf("Error deleting sessions of %s: %v", user.Username, err)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// revokeSessions ends the sessions of username except keepID, writing a
+// 500 response if that fails.
+func revokeSessions(c *gin.Context, username, keepID string) bool {
+	if err := getStore().DeleteUserSessions(username, keepID); err != nil {
+		log.Printf("Error revoking sessions of %s: %v", username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The password was changed but other sessions could not be ended"})
+		return false
+	}
+	return true
+}
+
+func lookupUser(c *gin.Context) (User, bool) {
+	user, err := getStore().GetUser(c.Param("username"))
+	if errors.Is(err, ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return User{}, false
+	}
+	if err != nil {
+		log.Printf("Error loading user %s: %v", c.Param("username"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load user"})
+		return User{}, false
+	}
+	return user, true
+}
@@ -0,0 +1,165 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authServer serves the auth and user routes as main.go does, with an
+// admin account "admin" / "adminpass1" in a fresh store.
+func authServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	conf := DefaultConfig()
+	conf.Auth.AdminPassword = "adminpass1"
+	SetConfig(conf)
+	SetStore(NewMemoryStore())
+	if err := EnsureAdmin(); err != nil {
+		t.Fatal(err)
+	}
+	loginLimitersMu.Lock()
+	loginLimiters = make(map[string]*loginLimiter)
+	loginLimitersMu.Unlock()
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	r.POST("/api/v1/auth/login", HandleLogin)
+	api := r.Group("/api/v1", RequireAuth())
+	api.GET("/auth/me", HandleCurrentUser)
+	api.POST("/auth/password", HandleChangePassword)
+	users := api.Group("/users", RequireAdmin())
+	users.POST("", HandleCreateUser)
+	users.PUT("/:username", HandleUpdateUser)
+	return r
+}
+
+func serveJSON(r http.Handler, method, path, token, body string, header ...string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var m map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &m)
+	return w.Code, m
+}
+
+func login(t *testing.T, r http.Handler, username, password string) string {
+	t.Helper()
+	code, m := serveJSON(r, "POST", "/api/v1/auth/login", "", `{"username":"`+username+`","password":"`+password+`"}`)
+	token, _ := m["token"].(string)
+	if code != http.StatusOK || token == "" {
+		t.Fatalf("login %s: %d %v", username, code, m)
+	}
+	return token
+}
+
+func TestChangePasswordEndsOtherSessions(t *testing.T) {
+	r := authServer(t)
+	current := login(t, r, "admin", "adminpass1")
+	other := login(t, r, "admin", "adminpass1")
+
+	code, m := serveJSON(r, "POST", "/api/v1/auth/password", current, `{"currentPassword":"adminpass1","newPassword":"adminpass2"}`)
+	if code != http.StatusOK {
+		t.Fatalf("change password: %d %v", code, m)
+	}
+	if code, _ := serveJSON(r, "GET", "/api/v1/auth/me", current, ""); code != http.StatusOK {
+		t.Errorf("current session: %d, want 200", code)
+	}
+	if code, _ := serveJSON(r, "GET", "/api/v1/auth/me", other, ""); code != http.StatusUnauthorized {
+		t.Errorf("other session: %d, want 401", code)
+	}
+}
+
+func TestPasswordResetEndsUserSessions(t *testing.T) {
+	r := authServer(t)
+	admin := login(t, r, "admin", "adminpass1")
+	if code, m := serveJSON(r, "POST", "/api/v1/users", admin, `{"username":"alice","password":"alicepass1"}`); code != http.StatusOK {
+		t.Fatalf("create user: %d %v", code, m)
+	}
+	alice := login(t, r, "alice", "alicepass1")
+
+	if code, m := serveJSON(r, "PUT", "/api/v1/users/alice", admin, `{"password":"alicepass2"}`); code != http.StatusOK {
+		t.Fatalf("reset password: %d %v", code, m)
+	}
+	if code, _ := serveJSON(r, "GET", "/api/v1/auth/me", alice, ""); code != http.StatusUnauthorized {
+		t.Errorf("alice's session: %d, want 401", code)
+	}
+	if code, _ := serveJSON(r, "GET", "/api/v1/auth/me", admin, ""); code != http.StatusOK {
+		t.Errorf("admin's session: %d, want 200", code)
+	}
+}
+
+func TestCreateUserExists(t *testing.T) {
+	r := authServer(t)
+	admin := login(t, r, "admin", "adminpass1")
+	body := `{"username":"alice","password":"alicepass1"}`
+	if code, m := serveJSON(r, "POST", "/api/v1/users", admin, body); code != http.StatusOK {
+		t.Fatalf("create user: %d %v", code, m)
+	}
+	if code, _ := serveJSON(r, "POST", "/api/v1/users", admin, body); code != http.StatusConflict {
+		t.Errorf("second create: %d, want 409", code)
+	}
+	login(t, r, "alice", "alicepass1")
+}
+
+func TestLoginRateLimitIgnoresForwardedFor(t *testing.T) {
+	r := authServer(t)
+	body := `{"username":"admin","password":"wrong"}`
+	limited := false
+	for i := 0; i < 20 && !limited; i++ {
+		code, _ := serveJSON(r, "POST", "/api/v1/auth/login", "", body, "X-Forwarded-For", "198.51.100."+string(rune('0'+i%10)))
+		limited = code == http.StatusTooManyRequests
+	}
+	if !limited {
+		t.Error("a changing X-Forwarded-For header avoided the login rate limit")
+	}
+}
+
+func TestBoltUserSessions(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := store.CreateUser(User{Username: "alice", Role: RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateUser(User{Username: "alice", Role: RoleAdmin}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("second CreateUser: %v, want ErrUserExists", err)
+	}
+	if u, _ := store.GetUser("alice"); u.Role != RoleUser {
+		t.Errorf("role = %s, the existing user was replaced", u.Role)
+	}
+
+	for _, s := range []Session{{ID: "a1", Username: "alice"}, {ID: "a2", Username: "alice"}, {ID: "b1", Username: "bob"}} {
+		if err := store.SaveSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteUserSessions("alice", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]bool{"a1": false, "a2": true, "b1": true} {
+		if _, err := store.GetSession(id); (err == nil) != want {
+			t.Errorf("session %s: err = %v, want kept %v", id, err, want)
+		}
+	}
+}
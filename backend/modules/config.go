@@ -0,0 +1,512 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the backend configuration. Values are resolved in the order
+// defaults, config file, environment, command-line flags.
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Scan    ScanConfig    `yaml:"scan"`
+	AI      AIConfig      `yaml:"ai"`
+	Storage StorageConfig `yaml:"storage"`
+	Notify  NotifyConfig  `yaml:"notify"`
+	Auth    AuthConfig    `yaml:"auth"`
+}
+
+type ServerConfig struct {
+	Listen         string   `yaml:"listen"`
+	TLSCert        string   `yaml:"tlsCert"`
+	TLSKey         string   `yaml:"tlsKey"`
+	AllowedOrigins []string `yaml:"allowedOrigins"` // cross-origin web UIs; the UI served by the backend needs none
+	TrustedProxies []string `yaml:"trustedProxies"` // reverse proxies whose X-Forwarded-For is believed
+	FrontendDir    string   `yaml:"frontendDir"`    // web UI served at /, if it contains index.html
+	Mode           string   `yaml:"mode"`           // gin mode: release, debug or test
+}
+
+type ScanConfig struct {
+	HTTPTimeout          time.Duration          `yaml:"httpTimeout"`
+	DialTimeout          time.Duration          `yaml:"dialTimeout"`
+	RequestsPerSecond    float64                `yaml:"requestsPerSecond"`
+	Concurrency          int                    `yaml:"concurrency"`
+	PerHostRPS           float64                `yaml:"perHostRps"`
+	Ports                []int                  `yaml:"ports"`                // scanned when a request selects none
+	PortConcurrency      int                    `yaml:"portConcurrency"`      // ports dialled at once per host IP
+	Resolvers            []string               `yaml:"resolvers"`            // DNS servers, empty for the system resolver
+	TakeoverFingerprints string                 `yaml:"takeoverFingerprints"` // JSON file replacing the bundled list, re-read per job
+	SecretRules          []string               `yaml:"secretRules"`          // JSON rule files merged into the bundled rules, re-read per job
+	Crawl                CrawlConfig            `yaml:"crawl"`
+	Profiles             map[string]ScanProfile `yaml:"profiles"`
+}
+
+// CrawlConfig bounds the deep crawl of each subdomain. Requests can override
+// every field.
+type CrawlConfig struct {
+	MaxDepth      int      `yaml:"maxDepth"`      // links followed from the landing page
+	MaxPages      int      `yaml:"maxPages"`      // pages fetched per subdomain, including the landing page
+	Scope         string   `yaml:"scope"`         // host or domain (same registrable domain)
+	Allow         []string `yaml:"allow"`         // regular expressions; if set, crawled URLs must match one
+	Deny          []string `yaml:"deny"`          // regular expressions excluding URLs from the crawl
+	RespectRobots bool     `yaml:"respectRobots"` // skip paths disallowed by robots.txt
+	MaxScripts    int      `yaml:"maxScripts"`    // same-domain scripts opened by the JavaScript analysis, 0 disables it
+}
+
+// ScanProfile is a named preset for subdomain analysis, selected with the
+// profile form field. Explicit request values take precedence.
+type ScanProfile struct {
+	DeepCrawl         bool          `yaml:"deepCrawl"`
+	PortScan          bool          `yaml:"portScan"`
+	UDPScan           bool          `yaml:"udpScan"`
+	RequestsPerSecond float64       `yaml:"requestsPerSecond"`
+	Concurrency       int           `yaml:"concurrency"`
+	PerHostRPS        float64       `yaml:"perHostRps"`
+	Timeout           time.Duration `yaml:"timeout"`
+	CrawlDepth        int           `yaml:"crawlDepth"`
+	CrawlPages        int           `yaml:"crawlPages"`
+	Ports             string        `yaml:"ports"` // port profiles, ports and ranges, e.g. top-1000 or 1-1024,8080
+}
+
+type AIConfig struct {
+	DefaultProvider string                      `yaml:"defaultProvider"`
+	Providers       map[string]AIProviderConfig `yaml:"providers"`
+}
+
+type AIProviderConfig struct {
+	APIKey  string `yaml:"apiKey"`
+	Model   string `yaml:"model"`
+	BaseURL string `yaml:"baseURL"`
+}
+
+type StorageConfig struct {
+	Path string `yaml:"path"`
+}
+
+// AuthConfig controls logins. The admin account is only created when the
+// store has no users yet.
+type AuthConfig struct {
+	SessionTTL    time.Duration `yaml:"sessionTTL"`
+	AdminUsername string        `yaml:"adminUsername"`
+	AdminPassword string        `yaml:"adminPassword"` // generated and logged if empty
+}
+
+// NotifyConfig lists the sinks notified of every job, and the SMTP server
+// used by email sinks, including those of requests and schedules.
+type NotifyConfig struct {
+	Sinks       []NotifierConfig `yaml:"sinks"`
+	MinSeverity string           `yaml:"minSeverity"` // threshold of the findings event for sinks that set none, default high
+	SMTP        SMTPConfig       `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"` // default 587
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	// AllowedRecipients are the addresses, or "@domain" for a whole domain,
+	// that email sinks of requests and schedules may send to. Empty disables
+	// those sinks; the sinks of this file are not restricted.
+	AllowedRecipients []string `yaml:"allowedRecipients"`
+}
+
+var aiProviders = []string{"google", "openai", "deepseek"}
+
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Listen:      ":8080",
+			FrontendDir: "../frontend",
+			Mode:        "release",
+		},
+		Scan: ScanConfig{
+			HTTPTimeout:       10 * time.Second,
+			DialTimeout:       1 * time.Second,
+			RequestsPerSecond: 10,
+			Concurrency:       10,
+			Ports:             mustParsePorts("top-100"),
+			PortConcurrency:   100,
+			Crawl: CrawlConfig{
+				MaxDepth:      2,
+				MaxPages:      50,
+				Scope:         CrawlScopeHost,
+				RespectRobots: true,
+				MaxScripts:    20,
+			},
+			Profiles: map[string]ScanProfile{
+				"quick":    {},
+				"standard": {DeepCrawl: true},
+				"full":     {DeepCrawl: true, PortScan: true, UDPScan: true, CrawlDepth: 3, CrawlPages: 200, Ports: "top-1000"},
+			},
+		},
+		AI: AIConfig{
+			DefaultProvider: "google",
+			Providers: map[string]AIProviderConfig{
+				"google":   {Model: "gemini-1.5-flash-latest"},
+				"openai":   {Model: "gpt-3.5-turbo"},
+				"deepseek": {Model: "deepseek-chat", BaseURL: "https://api.deepseek.com"},
+			},
+		},
+		Storage: StorageConfig{
+			Path: "vuln_ai.db",
+		},
+		Auth: AuthConfig{
+			SessionTTL:    24 * time.Hour,
+			AdminUsername: "admin",
+		},
+	}
+}
+
+// LoadConfig returns the defaults overlaid with the YAML file at path (if
+// path is non-empty) and the VULN_AI_* environment variables.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+	cfg.fillProviderDefaults()
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// fillProviderDefaults restores default models and endpoints for providers
+// whose config file entry only sets some of the fields.
+func (c *Config) fillProviderDefaults() {
+	if c.AI.Providers == nil {
+		c.AI.Providers = make(map[string]AIProviderConfig)
+	}
+	for name, def := range DefaultConfig().AI.Providers {
+		p := c.AI.Providers[name]
+		if p.Model == "" {
+			p.Model = def.Model
+		}
+		if p.BaseURL == "" {
+			p.BaseURL = def.BaseURL
+		}
+		c.AI.Providers[name] = p
+	}
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	dur := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("VULN_AI_LISTEN", &c.Server.Listen)
+	str("VULN_AI_TLS_CERT", &c.Server.TLSCert)
+	str("VULN_AI_TLS_KEY", &c.Server.TLSKey)
+	str("VULN_AI_MODE", &c.Server.Mode)
+	if v, ok := os.LookupEnv("VULN_AI_ALLOWED_ORIGINS"); ok {
+		c.Server.AllowedOrigins = SplitList(v)
+	}
+	if v, ok := os.LookupEnv("VULN_AI_TRUSTED_PROXIES"); ok {
+		c.Server.TrustedProxies = SplitList(v)
+	}
+	dur("VULN_AI_HTTP_TIMEOUT", &c.Scan.HTTPTimeout)
+	dur("VULN_AI_DIAL_TIMEOUT", &c.Scan.DialTimeout)
+	if v, ok := os.LookupEnv("VULN_AI_RPS"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("VULN_AI_RPS: %w", err))
+		} else {
+			c.Scan.RequestsPerSecond = f
+		}
+	}
+	if v, ok := os.LookupEnv("VULN_AI_RESOLVERS"); ok {
+		c.Scan.Resolvers = SplitList(v)
+	}
+	str("VULN_AI_TAKEOVER_FINGERPRINTS", &c.Scan.TakeoverFingerprints)
+	if v, ok := os.LookupEnv("VULN_AI_SECRET_RULES"); ok {
+		c.Scan.SecretRules = SplitList(v)
+	}
+	str("VULN_AI_STORAGE_PATH", &c.Storage.Path)
+	str("VULN_AI_SMTP_PASSWORD", &c.Notify.SMTP.Password)
+	str("VULN_AI_ADMIN_PASSWORD", &c.Auth.AdminPassword)
+	dur("VULN_AI_SESSION_TTL", &c.Auth.SessionTTL)
+	str("VULN_AI_AI_PROVIDER", &c.AI.DefaultProvider)
+	for _, name := range aiProviders {
+		if v, ok := os.LookupEnv("VULN_AI_" + strings.ToUpper(name) + "_API_KEY"); ok {
+			if c.AI.Providers == nil {
+				c.AI.Providers = make(map[string]AIProviderConfig)
+			}
+			p := c.AI.Providers[name]
+			p.APIKey = v
+			c.AI.Providers[name] = p
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once so that a misconfigured
+// server fails at startup with a complete list of problems.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		fail("server.listen", "invalid address %q: %v", c.Server.Listen, err)
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		fail("server.tlsCert/tlsKey", "both a certificate and a key are required to enable TLS")
+	}
+	for _, f := range []struct{ field, path string }{{"server.tlsCert", c.Server.TLSCert}, {"server.tlsKey", c.Server.TLSKey}} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			fail(f.field, "%v", err)
+		}
+	}
+	for _, origin := range c.Server.AllowedOrigins {
+		if origin == "*" {
+			fail("server.allowedOrigins", "\"*\" is not allowed, list the origins of the web UI")
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			fail("server.allowedOrigins", "invalid origin %q, expected scheme://host[:port]", origin)
+		}
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			fail("server.trustedProxies", "invalid address %q, expected an IP or CIDR", proxy)
+		}
+	}
+	switch c.Server.Mode {
+	case "release", "debug", "test":
+	default:
+		fail("server.mode", "unknown mode %q, expected release, debug or test", c.Server.Mode)
+	}
+
+	if c.Scan.HTTPTimeout <= 0 {
+		fail("scan.httpTimeout", "must be positive")
+	}
+	if c.Scan.DialTimeout <= 0 {
+		fail("scan.dialTimeout", "must be positive")
+	}
+	if c.Scan.RequestsPerSecond <= 0 {
+		fail("scan.requestsPerSecond", "must be positive")
+	}
+	if c.Scan.Concurrency <= 0 {
+		fail("scan.concurrency", "must be positive")
+	}
+	if c.Scan.PerHostRPS < 0 {
+		fail("scan.perHostRps", "must not be negative")
+	}
+	if len(c.Scan.Ports) == 0 {
+		fail("scan.ports", "at least one port is required")
+	}
+	for _, p := range c.Scan.Ports {
+		if p < 1 || p > 65535 {
+			fail("scan.ports", "port %d out of range 1-65535", p)
+		}
+	}
+	if c.Scan.PortConcurrency <= 0 || c.Scan.PortConcurrency > maxPortConcurrency {
+		fail("scan.portConcurrency", "must be between 1 and %d", maxPortConcurrency)
+	}
+	for _, r := range c.Scan.Resolvers {
+		host := r
+		if h, _, err := net.SplitHostPort(r); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			fail("scan.resolvers", "invalid resolver %q, expected an IP address with optional port", r)
+		}
+	}
+	if c.Scan.TakeoverFingerprints != "" {
+		if _, err := LoadTakeoverFingerprints(c.Scan.TakeoverFingerprints); err != nil {
+			fail("scan.takeoverFingerprints", "%v", err)
+		}
+	}
+	if _, err := LoadSecretRules(c.Scan.SecretRules); err != nil {
+		fail("scan.secretRules", "%v", err)
+	}
+	if c.Scan.Crawl.MaxDepth < 0 {
+		fail("scan.crawl.maxDepth", "must not be negative")
+	}
+	if c.Scan.Crawl.MaxScripts < 0 {
+		fail("scan.crawl.maxScripts", "must not be negative")
+	}
+	if c.Scan.Crawl.MaxPages <= 0 {
+		fail("scan.crawl.maxPages", "must be positive")
+	}
+	if c.Scan.Crawl.Scope != CrawlScopeHost && c.Scan.Crawl.Scope != CrawlScopeDomain {
+		fail("scan.crawl.scope", "unknown scope %q, expected host or domain", c.Scan.Crawl.Scope)
+	}
+	for _, patterns := range [][]string{c.Scan.Crawl.Allow, c.Scan.Crawl.Deny} {
+		if _, err := compilePatterns(patterns); err != nil {
+			fail("scan.crawl", "%v", err)
+		}
+	}
+	for name, p := range c.Scan.Profiles {
+		field := "scan.profiles." + name
+		if p.RequestsPerSecond < 0 || p.PerHostRPS < 0 || p.Concurrency < 0 || p.Timeout < 0 || p.CrawlDepth < 0 || p.CrawlPages < 0 {
+			fail(field, "rates, concurrency, timeout and crawl limits must not be negative")
+		}
+		if p.Ports != "" {
+			if _, err := parsePorts(p.Ports); err != nil {
+				fail(field+".ports", "%v", err)
+			}
+		}
+	}
+
+	if !containsString(aiProviders, c.AI.DefaultProvider) {
+		fail("ai.defaultProvider", "unknown provider %q, expected one of %s", c.AI.DefaultProvider, strings.Join(aiProviders, ", "))
+	}
+	for name, p := range c.AI.Providers {
+		if !containsString(aiProviders, name) {
+			fail("ai.providers", "unknown provider %q", name)
+		}
+		if p.BaseURL != "" {
+			if u, err := url.Parse(p.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				fail("ai.providers."+name+".baseURL", "invalid URL %q", p.BaseURL)
+			}
+		}
+	}
+
+	if c.Storage.Path == "" {
+		fail("storage.path", "must not be empty")
+	} else if dir := filepath.Dir(c.Storage.Path); dir != "." {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			fail("storage.path", "directory %q does not exist", dir)
+		}
+	}
+
+	for i, sink := range c.Notify.Sinks {
+		field := fmt.Sprintf("notify.sinks[%d]", i)
+		if err := sink.validate(true); err != nil {
+			fail(field, "%v", err)
+		}
+		if sink.Type == SinkEmail && c.Notify.SMTP.Host == "" {
+			fail(field, "email sinks require notify.smtp.host")
+		}
+	}
+	if c.Notify.MinSeverity != "" && severityRank(c.Notify.MinSeverity) < 0 {
+		fail("notify.minSeverity", "unknown severity %q, expected one of %s", c.Notify.MinSeverity, strings.Join(severities, ", "))
+	}
+	if c.Notify.SMTP.Host != "" && c.Notify.SMTP.From == "" {
+		fail("notify.smtp.from", "required when an SMTP host is set")
+	}
+	if c.Notify.SMTP.From != "" {
+		if _, err := parseEmailAddress(c.Notify.SMTP.From); err != nil {
+			fail("notify.smtp.from", "%v", err)
+		}
+	}
+	if c.Auth.SessionTTL <= 0 {
+		fail("auth.sessionTTL", "must be positive")
+	}
+	if !usernamePattern.MatchString(c.Auth.AdminUsername) {
+		fail("auth.adminUsername", "invalid username %q", c.Auth.AdminUsername)
+	}
+	if p := c.Auth.AdminPassword; p != "" && (len(p) < minPasswordLength || len(p) > maxPasswordLength) {
+		fail("auth.adminPassword", "must have 8 to 72 bytes")
+	}
+	if c.Notify.SMTP.Port < 0 || c.Notify.SMTP.Port > 65535 {
+		fail("notify.smtp.port", "port %d out of range 1-65535", c.Notify.SMTP.Port)
+	}
+	return errors.Join(errs...)
+}
+
+var cfg = DefaultConfig()
+
+// SetConfig installs the configuration used by the scan modules. It must be
+// called before the server starts accepting requests.
+func SetConfig(c Config) {
+	cfg = c
+}
+
+// applyScanProfile fills the options the caller left empty from the named
+// profile. It returns false if the profile does not exist.
+func applyScanProfile(req *SubdomainAnalysisRequest) bool {
+	if req.Profile == "" {
+		return true
+	}
+	p, ok := cfg.Scan.Profiles[req.Profile]
+	if !ok {
+		return false
+	}
+	if req.IsDeepCrawl == "" {
+		req.IsDeepCrawl = strconv.FormatBool(p.DeepCrawl)
+	}
+	if req.IsPortScan == "" {
+		req.IsPortScan = strconv.FormatBool(p.PortScan)
+	}
+	if req.IsUDPScan == "" {
+		req.IsUDPScan = strconv.FormatBool(p.UDPScan)
+	}
+	if req.RequestsPerSecond == "" && p.RequestsPerSecond > 0 {
+		req.RequestsPerSecond = strconv.FormatFloat(p.RequestsPerSecond, 'f', -1, 64)
+	}
+	if req.Concurrency == "" && p.Concurrency > 0 {
+		req.Concurrency = strconv.Itoa(p.Concurrency)
+	}
+	if req.PerHostRPS == "" && p.PerHostRPS > 0 {
+		req.PerHostRPS = strconv.FormatFloat(p.PerHostRPS, 'f', -1, 64)
+	}
+	if req.Timeout == "" && p.Timeout > 0 {
+		req.Timeout = strconv.Itoa(int(p.Timeout / time.Second))
+	}
+	if req.CrawlDepth == "" && p.CrawlDepth > 0 {
+		req.CrawlDepth = strconv.Itoa(p.CrawlDepth)
+	}
+	if req.CrawlPages == "" && p.CrawlPages > 0 {
+		req.CrawlPages = strconv.Itoa(p.CrawlPages)
+	}
+	if req.Ports == "" {
+		req.Ports = p.Ports
+	}
+	return true
+}
+
+// SplitList splits a comma-separated list, dropping empty entries.
+func SplitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
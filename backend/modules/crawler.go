@@ -0,0 +1,457 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Endpoint is a URL discovered while crawling a subdomain.
+type Endpoint struct {
+	URL    string   `json:"URL"`
+	Method string   `json:"Method"` // GET, or the method of a form
+	Source string   `json:"Source"` // page it was found on, robots.txt or a sitemap
+	Depth  int      `json:"Depth"`  // links followed from the landing page
+	Params []string `json:"Params"` // field names of a form
+}
+
+// Crawl scopes, selecting which discovered URLs are fetched in turn.
+const (
+	CrawlScopeHost   = "host"   // the subdomain itself
+	CrawlScopeDomain = "domain" // any host under the same registrable domain
+)
+
+const (
+	maxCrawlBody      = 2 << 20
+	maxSitemapURLs    = 1000
+	maxSitemapFetches = 5
+)
+
+// crawlOptions bounds the deep crawl of a subdomain. Scope, allow and deny
+// decide which URLs are fetched; every discovered URL is still reported.
+type crawlOptions struct {
+	maxDepth int
+	maxPages int
+	scope    string
+	allow    []*regexp.Regexp
+	deny     []*regexp.Regexp
+	robots   bool
+	scripts  int // scripts opened by the JavaScript analysis
+}
+
+// newCrawlOptions combines the request's crawl fields with the config.
+func newCrawlOptions(req SubdomainAnalysisRequest) (crawlOptions, error) {
+	c := cfg.Scan.Crawl
+	opts := crawlOptions{
+		maxDepth: parseNonNegativeInt(req.CrawlDepth, c.MaxDepth),
+		maxPages: parsePositiveInt(req.CrawlPages, c.MaxPages),
+		scope:    c.Scope,
+		robots:   c.RespectRobots,
+		scripts:  c.MaxScripts,
+	}
+	if req.CrawlScope != "" {
+		opts.scope = req.CrawlScope
+	}
+	if opts.scope != CrawlScopeHost && opts.scope != CrawlScopeDomain {
+		return opts, fmt.Errorf("unknown crawl scope %q, expected host or domain", opts.scope)
+	}
+	allow, deny := c.Allow, c.Deny
+	if len(req.CrawlAllow) > 0 {
+		allow = req.CrawlAllow
+	}
+	if len(req.CrawlDeny) > 0 {
+		deny = req.CrawlDeny
+	}
+	var err error
+	if opts.allow, err = compilePatterns(allow); err != nil {
+		return opts, err
+	}
+	if opts.deny, err = compilePatterns(deny); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid crawl pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+type crawlItem struct {
+	url   *url.URL
+	depth int
+}
+
+// crawler walks one subdomain breadth first. It is not safe for concurrent
+// use; each subdomain gets its own.
+type crawler struct {
+	sess   *scanSession
+	opts   crawlOptions
+	root   *url.URL
+	domain string // registrable domain of root, for CrawlScopeDomain
+
+	seen      map[string]bool
+	endpoints []Endpoint
+	secrets   []SecretMatch
+	queue     []crawlItem
+	pages     int
+	rules     []robotsRule
+}
+
+// crawlSite crawls from the landing page, whose body the caller has already
+// read, and returns the endpoints found ordered by depth, and the secrets
+// exposed by the pages it fetched.
+func crawlSite(ctx context.Context, sess *scanSession, opts crawlOptions, landing *url.URL, body []byte) ([]Endpoint, []SecretMatch) {
+	c := &crawler{
+		sess:   sess,
+		opts:   opts,
+		root:   landing,
+		domain: registrableDomain(landing.Hostname()),
+		seen:   map[string]bool{"GET " + normalizeURL(landing): true},
+		pages:  1,
+	}
+	c.ingestRobots(ctx)
+	c.secrets = sess.secrets.Scan(landing.String(), body)
+	c.parsePage(landing, body, 0)
+	for len(c.queue) > 0 && c.pages < opts.maxPages && ctx.Err() == nil {
+		item := c.queue[0]
+		c.queue = c.queue[1:]
+		c.fetchPage(ctx, item)
+	}
+
+	sort.SliceStable(c.endpoints, func(i, j int) bool {
+		if c.endpoints[i].Depth != c.endpoints[j].Depth {
+			return c.endpoints[i].Depth < c.endpoints[j].Depth
+		}
+		return c.endpoints[i].URL < c.endpoints[j].URL
+	})
+	return c.endpoints, c.secrets
+}
+
+// add records an endpoint once per method and normalized URL, and queues GET
+// links for fetching when they are in scope.
+func (c *crawler) add(u *url.URL, method, source string, depth int, params []string) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	norm := normalizeURL(u)
+	key := method + " " + norm
+	if params != nil {
+		key = "form " + key // a form must not be hidden by a link to its action
+	}
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+	c.endpoints = append(c.endpoints, Endpoint{URL: norm, Method: method, Source: source, Depth: depth, Params: params})
+	if method == "GET" && depth <= c.opts.maxDepth && isPageURL(u) && c.inScope(u, norm) && c.robotsAllowed(u) {
+		c.queue = append(c.queue, crawlItem{url: u, depth: depth})
+	}
+}
+
+func (c *crawler) inScope(u *url.URL, norm string) bool {
+	host := strings.ToLower(u.Hostname())
+	switch c.opts.scope {
+	case CrawlScopeHost:
+		if host != strings.ToLower(c.root.Hostname()) {
+			return false
+		}
+	case CrawlScopeDomain:
+		if registrableDomain(host) != c.domain {
+			return false
+		}
+	}
+	for _, re := range c.opts.deny {
+		if re.MatchString(norm) {
+			return false
+		}
+	}
+	if len(c.opts.allow) == 0 {
+		return true
+	}
+	for _, re := range c.opts.allow {
+		if re.MatchString(norm) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *crawler) get(ctx context.Context, u *url.URL) (*http.Response, []byte, error) {
+	return fetchBody(ctx, c.sess, u, maxCrawlBody)
+}
+
+func (c *crawler) fetchPage(ctx context.Context, item crawlItem) {
+	c.pages++
+	resp, body, err := c.get(ctx, item.url)
+	if err != nil || resp.StatusCode >= 400 {
+		return
+	}
+	// Redirects may leave the scope; only parse pages that stayed inside.
+	final := resp.Request.URL
+	if !c.inScope(final, normalizeURL(final)) {
+		return
+	}
+	c.secrets = append(c.secrets, c.sess.secrets.Scan(final.String(), body)...)
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return
+	}
+	c.parsePage(final, body, item.depth)
+}
+
+// parsePage records the links, resources and forms of an HTML page found at
+// the given depth.
+func (c *crawler) parsePage(page *url.URL, body []byte, depth int) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	base := page
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := page.Parse(href); err == nil {
+			base = u
+		}
+	}
+	source := normalizeURL(page)
+	resolve := func(ref string) (*url.URL, bool) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || !isInterestingEndpoint(ref) {
+			return nil, false
+		}
+		u, err := base.Parse(ref)
+		return u, err == nil
+	}
+
+	doc.Find("a[href], link[href], area[href], script[src], iframe[src], frame[src]").Each(func(i int, s *goquery.Selection) {
+		ref, ok := s.Attr("href")
+		if !ok {
+			ref, _ = s.Attr("src")
+		}
+		if u, ok := resolve(ref); ok {
+			c.add(u, "GET", source, depth+1, nil)
+		}
+	})
+	doc.Find("form").Each(func(i int, s *goquery.Selection) {
+		action, _ := s.Attr("action")
+		if action == "" {
+			action = page.String()
+		}
+		u, ok := resolve(action)
+		if !ok {
+			return
+		}
+		method := strings.ToUpper(strings.TrimSpace(s.AttrOr("method", "GET")))
+		if method != "POST" {
+			method = "GET"
+		}
+		var params []string
+		s.Find("input[name], select[name], textarea[name], button[name]").Each(func(i int, f *goquery.Selection) {
+			if name := f.AttrOr("name", ""); !containsString(params, name) {
+				params = append(params, name)
+			}
+		})
+		// Forms are reported but never submitted.
+		c.add(u, method, source, depth+1, params)
+	})
+}
+
+// robotsRule is an Allow or Disallow line of robots.txt.
+type robotsRule struct {
+	allow   bool
+	path    string
+	pattern *regexp.Regexp
+}
+
+// ingestRobots reads robots.txt: disallowed paths are reported as endpoints
+// (they are often the interesting ones), the rules for all user agents are
+// kept to filter the crawl, and the listed sitemaps are read.
+func (c *crawler) ingestRobots(ctx context.Context) {
+	robotsURL := c.root.ResolveReference(&url.URL{Path: "/robots.txt"})
+	var sitemaps []string
+	if resp, body, err := c.get(ctx, robotsURL); err == nil && resp.StatusCode == http.StatusOK {
+		var rules []robotsRule
+		rules, sitemaps = parseRobots(body)
+		// Set the rules first so that add does not queue the disallowed
+		// paths it records.
+		if c.opts.robots {
+			c.rules = rules
+		}
+		for _, r := range rules {
+			if !r.allow && !strings.ContainsAny(r.path, "*$") {
+				c.add(c.root.ResolveReference(&url.URL{Path: r.path}), "GET", "robots.txt", 1, nil)
+			}
+		}
+	}
+	if len(sitemaps) == 0 {
+		sitemaps = []string{c.root.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()}
+	}
+	c.ingestSitemaps(ctx, sitemaps)
+}
+
+// parseRobots returns the rules of the "User-agent: *" group and every
+// Sitemap line.
+func parseRobots(body []byte) ([]robotsRule, []string) {
+	var rules []robotsRule
+	var sitemaps []string
+	inGroup, groupStarted := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share one group.
+			if groupStarted {
+				inGroup, groupStarted = false, false
+			}
+			if value == "*" {
+				inGroup = true
+			}
+		case "allow", "disallow":
+			groupStarted = true
+			if inGroup && value != "" {
+				rules = append(rules, robotsRule{allow: key == "allow", path: value, pattern: robotsPattern(value)})
+			}
+		case "sitemap":
+			sitemaps = append(sitemaps, value)
+		}
+	}
+	return rules, sitemaps
+}
+
+// robotsPattern turns a robots.txt path with "*" and "$" into a regexp
+// anchored at the start of the path.
+func robotsPattern(p string) *regexp.Regexp {
+	anchored := strings.HasSuffix(p, "$")
+	p = strings.TrimSuffix(p, "$")
+	parts := strings.Split(p, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// robotsAllowed applies the longest matching rule, Allow winning ties.
+func (c *crawler) robotsAllowed(u *url.URL) bool {
+	if !strings.EqualFold(u.Host, c.root.Host) {
+		return true // rules only cover the crawled host
+	}
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	allowed, best := true, -1
+	for _, r := range c.rules {
+		if r.pattern.MatchString(target) && (len(r.path) > best || (len(r.path) == best && r.allow)) {
+			allowed, best = r.allow, len(r.path)
+		}
+	}
+	return allowed
+}
+
+// ingestSitemaps reports the URLs of the given sitemaps, following sitemap
+// indexes, within fixed limits.
+func (c *crawler) ingestSitemaps(ctx context.Context, sitemaps []string) {
+	fetched, found := 0, 0
+	for len(sitemaps) > 0 && fetched < maxSitemapFetches && ctx.Err() == nil {
+		u, err := c.root.Parse(sitemaps[0])
+		sitemaps = sitemaps[1:]
+		if err != nil || !c.inScope(u, normalizeURL(u)) {
+			continue
+		}
+		fetched++
+		resp, body, err := c.get(ctx, u)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var doc struct {
+			URLs     []string `xml:"url>loc"`
+			Sitemaps []string `xml:"sitemap>loc"`
+		}
+		if xml.Unmarshal(body, &doc) != nil {
+			continue
+		}
+		sitemaps = append(sitemaps, doc.Sitemaps...)
+		for _, loc := range doc.URLs {
+			if found >= maxSitemapURLs {
+				return
+			}
+			if page, err := u.Parse(strings.TrimSpace(loc)); err == nil {
+				found++
+				c.add(page, "GET", normalizeURL(u), 1, nil)
+			}
+		}
+	}
+}
+
+// normalizeURL returns the form of u used to deduplicate endpoints: lower
+// case scheme and host, no default port or fragment, a non-empty path and
+// sorted query parameters.
+func normalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if port := n.Port(); (n.Scheme == "http" && port == "80") || (n.Scheme == "https" && port == "443") {
+		n.Host = n.Hostname()
+	}
+	n.Fragment, n.RawFragment = "", ""
+	n.User = nil
+	if n.Path == "" {
+		n.Path, n.RawPath = "/", ""
+	}
+	if n.RawQuery != "" {
+		n.RawQuery = n.Query().Encode()
+	}
+	return n.String()
+}
+
+func registrableDomain(host string) string {
+	host = strings.ToLower(host)
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+	return host
+}
+
+// isPageURL reports whether u may be an HTML page rather than a static asset.
+func isPageURL(u *url.URL) bool {
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".js", ".mjs", ".css", ".map", ".json", ".xml", ".txt",
+		".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp", ".bmp",
+		".woff", ".woff2", ".ttf", ".eot", ".otf",
+		".pdf", ".zip", ".gz", ".tar", ".rar", ".7z", ".exe", ".dmg", ".iso",
+		".mp3", ".mp4", ".avi", ".mov", ".webm", ".wav", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx":
+		return false
+	}
+	return true
+}
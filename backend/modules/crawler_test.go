@@ -0,0 +1,39 @@
+package modules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestCrawlSkipsRobotsDisallowed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /admin/\n"))
+	})
+	mux.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("fetched %s, which robots.txt disallows", r.URL.Path)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts, err := newCrawlOptions(SubdomainAnalysisRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.robots = true
+	landing, _ := url.Parse(srv.URL + "/")
+	endpoints, _ := crawlSite(context.Background(), newScanSession(1000, 0), opts, landing, []byte("<html></html>"))
+
+	found := false
+	for _, ep := range endpoints {
+		if ep.URL == srv.URL+"/admin/" && ep.Source == "robots.txt" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("disallowed path not reported, endpoints: %+v", endpoints)
+	}
+}
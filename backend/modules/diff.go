@@ -0,0 +1,243 @@
+package modules
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobDiff is what changed between two subdomain analysis runs, From the
+// baseline and To the newer one.
+type JobDiff struct {
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Added   []string        `json:"added"`   // subdomains only in To
+	Removed []string        `json:"removed"` // subdomains only in From
+	Changed []SubdomainDiff `json:"changed"`
+}
+
+// SubdomainDiff lists the changes of a subdomain present in both runs.
+type SubdomainDiff struct {
+	Subdomain           string       `json:"subdomain"`
+	Reachability        *ValueChange `json:"reachability,omitempty"`
+	StatusCode          *ValueChange `json:"statusCode,omitempty"`
+	Priority            *ValueChange `json:"priority,omitempty"`
+	AddedEndpoints      []string     `json:"addedEndpoints,omitempty"` // "METHOD URL"
+	RemovedEndpoints    []string     `json:"removedEndpoints,omitempty"`
+	AddedTechnologies   []string     `json:"addedTechnologies,omitempty"`
+	RemovedTechnologies []string     `json:"removedTechnologies,omitempty"`
+	OpenedPorts         []string     `json:"openedPorts,omitempty"` // "22/tcp ssh"
+	ClosedPorts         []string     `json:"closedPorts,omitempty"`
+}
+
+type ValueChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// HandleJobDiff compares the results of the subdomain jobs :jobID (the
+// baseline) and :otherJobID, as JSON or, with ?format=text, as plain text.
+func HandleJobDiff(c *gin.Context) {
+	from, ok := lookupJob(c)
+	if !ok {
+		return
+	}
+	to, ok := lookupJobParam(c, "otherJobID")
+	if !ok {
+		return
+	}
+	if from.Kind != JobKindSubdomain || to.Kind != JobKindSubdomain {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only subdomain jobs can be compared"})
+		return
+	}
+	before, ok := GetSubdomainResults(from.ID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Results not found for job " + from.ID})
+		return
+	}
+	after, ok := GetSubdomainResults(to.ID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Results not found for job " + to.ID})
+		return
+	}
+
+	diff := DiffSubdomainResults(before, after)
+	diff.From, diff.To = from.ID, to.ID
+	if c.Query("format") == "text" {
+		c.String(http.StatusOK, "%s", diff.Text())
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// DiffSubdomainResults compares two result sets by normalized subdomain.
+func DiffSubdomainResults(before, after []AnalysisResult) JobDiff {
+	diff := JobDiff{Added: []string{}, Removed: []string{}, Changed: []SubdomainDiff{}}
+	old := make(map[string]AnalysisResult, len(before))
+	for _, r := range before {
+		old[normalizeHostname(r.Subdomain)] = r
+	}
+	seen := make(map[string]bool, len(after))
+	for _, r := range after {
+		key := normalizeHostname(r.Subdomain)
+		seen[key] = true
+		prev, ok := old[key]
+		if !ok {
+			diff.Added = append(diff.Added, r.Subdomain)
+			continue
+		}
+		if d, changed := diffSubdomain(prev, r); changed {
+			diff.Changed = append(diff.Changed, d)
+		}
+	}
+	for _, r := range before {
+		if !seen[normalizeHostname(r.Subdomain)] {
+			diff.Removed = append(diff.Removed, r.Subdomain)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Subdomain < diff.Changed[j].Subdomain })
+	return diff
+}
+
+func diffSubdomain(before, after AnalysisResult) (SubdomainDiff, bool) {
+	d := SubdomainDiff{Subdomain: after.Subdomain}
+	change := func(from, to string) *ValueChange {
+		if from == to {
+			return nil
+		}
+		return &ValueChange{From: from, To: to}
+	}
+	reachability := func(r AnalysisResult) string {
+		if r.IsReachable {
+			return "reachable"
+		}
+		return "unreachable"
+	}
+	status := func(r AnalysisResult) string {
+		if !r.IsReachable {
+			return ""
+		}
+		return fmt.Sprint(r.StatusCode)
+	}
+	d.Reachability = change(reachability(before), reachability(after))
+	d.StatusCode = change(status(before), status(after))
+	d.Priority = change(before.Priority, after.Priority)
+
+	endpoints := func(r AnalysisResult) map[string]string {
+		m := make(map[string]string)
+		for _, ep := range r.Endpoints {
+			label := ep.Method + " " + ep.URL
+			m[label] = label
+		}
+		return m
+	}
+	technologies := func(r AnalysisResult) map[string]string {
+		m := make(map[string]string)
+		for _, t := range r.Technologies {
+			m[t] = t
+		}
+		return m
+	}
+	// Ports are compared by number and protocol; a new banner on the same
+	// port is not a change.
+	ports := func(r AnalysisResult) map[string]string {
+		m := make(map[string]string)
+		for _, p := range r.Ports {
+			label := fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+			if p.Service != "" {
+				label += " " + p.Service
+			}
+			m[fmt.Sprintf("%d/%s", p.Port, p.Protocol)] = label
+		}
+		return m
+	}
+	d.AddedEndpoints, d.RemovedEndpoints = diffSets(endpoints(before), endpoints(after))
+	d.AddedTechnologies, d.RemovedTechnologies = diffSets(technologies(before), technologies(after))
+	d.OpenedPorts, d.ClosedPorts = diffSets(ports(before), ports(after))
+
+	changed := d.Reachability != nil || d.StatusCode != nil || d.Priority != nil ||
+		len(d.AddedEndpoints)+len(d.RemovedEndpoints)+len(d.AddedTechnologies)+len(d.RemovedTechnologies)+len(d.OpenedPorts)+len(d.ClosedPorts) > 0
+	return d, changed
+}
+
+// diffSets returns the labels of the keys only in after and only in before,
+// sorted.
+func diffSets(before, after map[string]string) (added, removed []string) {
+	for key, label := range after {
+		if _, ok := before[key]; !ok {
+			added = append(added, label)
+		}
+	}
+	for key, label := range before {
+		if _, ok := after[key]; !ok {
+			removed = append(removed, label)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Empty reports whether the runs had the same results.
+func (d JobDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Text renders the diff in the style of the subdomain reports.
+func (d JobDiff) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Diff: %s -> %s\n", d.From, d.To)
+	if d.Empty() {
+		b.WriteString("\nNo changes.\n")
+		return b.String()
+	}
+	list := func(title string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for _, v := range values {
+			fmt.Fprintf(&b, "- %s\n", v)
+		}
+	}
+	list("New Subdomains", d.Added)
+	list("Removed Subdomains", d.Removed)
+	if len(d.Changed) > 0 {
+		b.WriteString("\nChanged Subdomains:\n")
+	}
+	for _, s := range d.Changed {
+		fmt.Fprintf(&b, "\n%s\n", s.Subdomain)
+		value := func(name string, v *ValueChange) {
+			if v != nil {
+				fmt.Fprintf(&b, "  %s: %s -> %s\n", name, orNone(v.From), orNone(v.To))
+			}
+		}
+		value("Reachability", s.Reachability)
+		value("Status", s.StatusCode)
+		value("Priority", s.Priority)
+		items := func(prefix string, values []string) {
+			for _, v := range values {
+				fmt.Fprintf(&b, "  %s %s\n", prefix, v)
+			}
+		}
+		items("+ port", s.OpenedPorts)
+		items("- port", s.ClosedPorts)
+		items("+ technology", s.AddedTechnologies)
+		items("- technology", s.RemovedTechnologies)
+		items("+ endpoint", s.AddedEndpoints)
+		items("- endpoint", s.RemovedEndpoints)
+	}
+	return b.String()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
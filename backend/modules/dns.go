@@ -0,0 +1,384 @@
+package modules
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSRecords holds the records collected for a subdomain before it is probed.
+type DNSRecords struct {
+	A          []string `json:"A"`
+	AAAA       []string `json:"AAAA"`
+	CNAME      string   `json:"CNAME"`
+	CNAMEChain []string `json:"CNAMEChain"` // every alias followed from the name
+	MX         []string `json:"MX"`
+	TXT        []string `json:"TXT"`
+	NS         []string `json:"NS"`
+	NXDomain   bool     `json:"NXDomain"` // no record of any type exists
+	Dangling   bool     `json:"Dangling"` // the alias chain ends in a name that does not resolve
+	Wildcard   bool     `json:"Wildcard"` // answers match the parent zone's wildcard
+	Error      string   `json:"Error"`    // lookup failure other than a missing name
+}
+
+// Resolves reports whether the name has an address or an alias.
+func (r *DNSRecords) Resolves() bool {
+	return len(r.A) > 0 || len(r.AAAA) > 0 || r.CNAME != ""
+}
+
+// newNetResolver returns a resolver that spreads queries over the given DNS
+// servers ("host" or "host:port"). With no servers the system resolver is used.
+func newNetResolver(servers []string) *net.Resolver {
+	if len(servers) == 0 {
+		return net.DefaultResolver
+	}
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[atomic.AddUint32(&next, 1)%uint32(len(servers))]
+			var d net.Dialer
+			return d.DialContext(ctx, network, withDefaultPort(server, "53"))
+		},
+	}
+}
+
+// withDefaultPort appends port to addr if it does not carry one already.
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// dnsResolver is the DNS stage of a subdomain job. It remembers the wildcard
+// answers of every parent zone it has seen so that each zone is probed once.
+type dnsResolver struct {
+	resolver *net.Resolver
+	servers  []string // empty for the system resolver
+
+	mu    sync.Mutex
+	zones map[string]*wildcardZone
+}
+
+type wildcardZone struct {
+	mu     sync.Mutex
+	done   bool
+	answer *wildcardAnswer // nil when the zone has no wildcard
+}
+
+func newDNSResolver(servers []string) *dnsResolver {
+	return &dnsResolver{
+		resolver: newNetResolver(servers),
+		servers:  servers,
+		zones:    make(map[string]*wildcardZone),
+	}
+}
+
+// Resolve collects the records of host and flags answers that only exist
+// because of a wildcard on the parent zone.
+func (d *dnsResolver) Resolve(ctx context.Context, host string) *DNSRecords {
+	rec := &DNSRecords{}
+	notFound := 0
+	lookup := func(fn func() error) bool {
+		err := fn()
+		var dnsErr *net.DNSError
+		switch {
+		case err == nil:
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			notFound++
+			return false
+		case rec.Error == "":
+			rec.Error = err.Error()
+		}
+		return true
+	}
+
+	addrsFound := lookup(func() error {
+		ips, err := d.resolver.LookupIPAddr(ctx, host)
+		for _, ip := range ips {
+			if ip.IP.To4() != nil {
+				rec.A = append(rec.A, ip.IP.String())
+			} else {
+				rec.AAAA = append(rec.AAAA, ip.IP.String())
+			}
+		}
+		return err
+	})
+	lookup(func() error {
+		cname, err := d.resolver.LookupCNAME(ctx, host)
+		if cname = normalizeHostname(cname); err == nil && cname != normalizeHostname(host) {
+			rec.CNAME = cname
+		}
+		return err
+	})
+	lookup(func() error {
+		mxs, err := d.resolver.LookupMX(ctx, host)
+		for _, mx := range mxs {
+			rec.MX = append(rec.MX, normalizeHostname(mx.Host))
+		}
+		return err
+	})
+	lookup(func() error {
+		txts, err := d.resolver.LookupTXT(ctx, host)
+		rec.TXT = txts
+		return err
+	})
+	lookup(func() error {
+		nss, err := d.resolver.LookupNS(ctx, host)
+		for _, ns := range nss {
+			rec.NS = append(rec.NS, normalizeHostname(ns.Host))
+		}
+		return err
+	})
+	sort.Strings(rec.A)
+	sort.Strings(rec.AAAA)
+	if !addrsFound && rec.CNAME == "" {
+		// An alias to a missing name makes every lookup fail as if the
+		// name itself were missing; only the raw answer shows the alias.
+		if chain := d.danglingCNAME(ctx, host); len(chain) > 0 {
+			rec.CNAME, rec.CNAMEChain = chain[0], chain
+		}
+	}
+	if rec.CNAME != "" {
+		if rec.CNAMEChain == nil {
+			rec.CNAMEChain = d.followCNAME(ctx, rec.CNAME)
+		}
+		rec.Dangling = !addrsFound
+	}
+
+	// The resolver does not tell NXDOMAIN from "no records of this type", so
+	// a name only counts as missing when every lookup came back empty.
+	rec.NXDomain = notFound == 5 && !rec.Resolves()
+	if rec.Resolves() {
+		if w := d.wildcard(ctx, parentZone(host)); w != nil {
+			rec.Wildcard = w.matches(rec.A, rec.AAAA, rec.CNAME)
+		}
+	}
+	return rec
+}
+
+// followCNAME returns the alias chain starting at target, stopping at loops
+// and after a bounded number of hops.
+func (d *dnsResolver) followCNAME(ctx context.Context, target string) []string {
+	chain := []string{target}
+	for len(chain) < 10 {
+		next, err := d.resolver.LookupCNAME(ctx, target)
+		if next = normalizeHostname(next); err != nil || next == target || containsString(chain, next) {
+			break
+		}
+		chain = append(chain, next)
+		target = next
+	}
+	return chain
+}
+
+// danglingCNAME queries the job's DNS servers for host directly and returns
+// the alias chain of an NXDOMAIN answer, or nil if there is none.
+func (d *dnsResolver) danglingCNAME(ctx context.Context, host string) []string {
+	servers := d.servers
+	if len(servers) == 0 {
+		servers = systemNameservers()
+	}
+	name, err := dnsmessage.NewName(normalizeHostname(host) + ".")
+	if err != nil {
+		return nil
+	}
+	var idBytes [2]byte
+	rand.Read(idBytes[:])
+	id := binary.BigEndian.Uint16(idBytes[:])
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	for _, server := range servers {
+		reply, err := dnsExchange(ctx, withDefaultPort(server, "53"), query)
+		if err == nil {
+			return nxdomainCNAMEs(reply, id, normalizeHostname(host))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// dnsExchange sends query to server over UDP and returns the first reply.
+func dnsExchange(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1232)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// nxdomainCNAMEs returns the aliases followed from host in an NXDOMAIN
+// reply to query id.
+func nxdomainCNAMEs(reply []byte, id uint16, host string) []string {
+	var p dnsmessage.Parser
+	h, err := p.Start(reply)
+	if err != nil || h.ID != id || !h.Response || h.RCode != dnsmessage.RCodeNameError {
+		return nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil
+	}
+	aliases := make(map[string]string)
+	for {
+		rh, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		if rh.Type != dnsmessage.TypeCNAME {
+			if p.SkipAnswer() != nil {
+				break
+			}
+			continue
+		}
+		r, err := p.CNAMEResource()
+		if err != nil {
+			break
+		}
+		aliases[normalizeHostname(rh.Name.String())] = normalizeHostname(r.CNAME.String())
+	}
+	var chain []string
+	for next, ok := aliases[host]; ok && len(chain) < 10 && !containsString(chain, next); next, ok = aliases[next] {
+		chain = append(chain, next)
+	}
+	return chain
+}
+
+// systemNameservers returns the servers of /etc/resolv.conf.
+func systemNameservers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+func (d *dnsResolver) wildcard(ctx context.Context, zone string) *wildcardAnswer {
+	if zone == "" {
+		return nil
+	}
+	d.mu.Lock()
+	z, ok := d.zones[zone]
+	if !ok {
+		z = &wildcardZone{}
+		d.zones[zone] = z
+	}
+	d.mu.Unlock()
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if !z.done {
+		// ctx belongs to one host. If it ran out during detection the
+		// answer is incomplete, so the next host of the zone probes again.
+		answer := detectWildcard(ctx, d.resolver, zone)
+		if ctx.Err() != nil {
+			return answer
+		}
+		z.answer, z.done = answer, true
+	}
+	return z.answer
+}
+
+// wildcardAnswer is what a zone's wildcard record resolves to.
+type wildcardAnswer struct {
+	addrs  map[string]bool
+	cnames map[string]bool
+}
+
+// detectWildcard resolves random labels under zone. If any of them exists
+// the zone has a wildcard record and its answers are returned.
+func detectWildcard(ctx context.Context, resolver *net.Resolver, zone string) *wildcardAnswer {
+	w := &wildcardAnswer{addrs: make(map[string]bool), cnames: make(map[string]bool)}
+	for i := 0; i < 2; i++ {
+		name := randomLabel() + "." + zone
+		ips, err := resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			w.addrs[ip.IP.String()] = true
+		}
+		if cname, err := resolver.LookupCNAME(ctx, name); err == nil && normalizeHostname(cname) != name {
+			w.cnames[normalizeHostname(cname)] = true
+		}
+	}
+	if len(w.addrs) == 0 && len(w.cnames) == 0 {
+		return nil
+	}
+	return w
+}
+
+// matches reports whether a name's answers are indistinguishable from the
+// wildcard: the same alias, or addresses that all belong to the wildcard.
+func (w *wildcardAnswer) matches(a, aaaa []string, cname string) bool {
+	if cname != "" {
+		return w.cnames[cname]
+	}
+	addrs := append(append([]string(nil), a...), aaaa...)
+	if len(addrs) == 0 {
+		return false
+	}
+	for _, addr := range addrs {
+		if !w.addrs[addr] {
+			return false
+		}
+	}
+	return true
+}
+
+// parentZone strips the first label of host, or returns "" for single-label
+// names and top-level domains.
+func parentZone(host string) string {
+	host = normalizeHostname(host)
+	i := strings.IndexByte(host, '.')
+	if i < 0 || !strings.Contains(host[i+1:], ".") {
+		return ""
+	}
+	return host[i+1:]
+}
+
+func randomLabel() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "wc-" + hex.EncodeToString(b)
+}
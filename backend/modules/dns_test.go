@@ -0,0 +1,145 @@
+package modules
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsRecord is a name of the test zone: an alias, or an IPv4 address.
+type dnsRecord struct {
+	cname string
+	a     string
+}
+
+// dnsServer answers queries from zone the way a recursive resolver does:
+// aliases are followed and included in the answer, and a chain that ends in
+// a missing name is answered NXDOMAIN.
+func dnsServer(t *testing.T, zone map[string]dnsRecord) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := dnsAnswer(zone, buf[:n]); reply != nil {
+				conn.WriteTo(reply, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func dnsAnswer(zone map[string]dnsRecord, query []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	header := dnsmessage.Header{ID: h.ID, Response: true, RecursionDesired: h.RecursionDesired, RecursionAvailable: true}
+	var cnames []dnsmessage.Resource
+	name := q.Name
+	rec, ok := zone[normalizeHostname(name.String())]
+	for ok && rec.cname != "" && len(cnames) < 10 {
+		target := dnsmessage.MustNewName(rec.cname + ".")
+		cnames = append(cnames, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.CNAMEResource{CNAME: target},
+		})
+		name = target
+		rec, ok = zone[rec.cname]
+	}
+	if !ok {
+		header.RCode = dnsmessage.RCodeNameError
+	}
+
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	for _, r := range cnames {
+		b.CNAMEResource(r.Header, *r.Body.(*dnsmessage.CNAMEResource))
+	}
+	if ok && rec.a != "" && q.Type == dnsmessage.TypeA {
+		var a [4]byte
+		copy(a[:], net.ParseIP(rec.a).To4())
+		b.AResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AResource{A: a})
+	}
+	reply, _ := b.Finish()
+	return reply
+}
+
+func takeoverSession(t *testing.T, zone map[string]dnsRecord) *scanSession {
+	t.Helper()
+	sess := newScanSession(1000, 0)
+	t.Cleanup(sess.close)
+	sess.setDNS(newDNSResolver([]string{dnsServer(t, zone)}))
+	sess.takeover = takeoverFingerprints()
+	return sess
+}
+
+func TestResolveDanglingCNAME(t *testing.T) {
+	sess := takeoverSession(t, map[string]dnsRecord{
+		"old.example.com":    {cname: "legacy.example.com"},
+		"legacy.example.com": {cname: "gone-app.azurewebsites.net"},
+		"www.example.com":    {a: "192.0.2.10"},
+	})
+
+	rec := sess.dns.Resolve(context.Background(), "old.example.com")
+	if rec.NXDomain || !rec.Dangling || rec.CNAME != "legacy.example.com" {
+		t.Fatalf("records = %+v", rec)
+	}
+	if want := []string{"legacy.example.com", "gone-app.azurewebsites.net"}; !reflect.DeepEqual(rec.CNAMEChain, want) {
+		t.Errorf("chain = %v, want %v", rec.CNAMEChain, want)
+	}
+	if rec := sess.dns.Resolve(context.Background(), "missing.example.com"); !rec.NXDomain || rec.CNAME != "" {
+		t.Errorf("missing name: %+v", rec)
+	}
+
+	result := analyzeSingleSubdomain(context.Background(), sess, "old.example.com", false, false)
+	if result.Takeover == nil || result.Takeover.Service != "Microsoft Azure" || result.Takeover.Evidence != "NXDOMAIN" {
+		t.Errorf("takeover = %+v", result.Takeover)
+	}
+	if !hasRule(result.Findings, "subdomain-takeover") {
+		t.Errorf("findings = %+v", result.Findings)
+	}
+}
+
+func TestTakeoverBodyFingerprint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<h1>404</h1><p>There isn't a GitHub Pages site here.</p>"))
+	}))
+	defer srv.Close()
+	sess := takeoverSession(t, map[string]dnsRecord{
+		"blog.example.com": {cname: "acme.github.io"},
+		"acme.github.io":   {a: "127.0.0.1"},
+	})
+
+	target := net.JoinHostPort("blog.example.com", strconv.Itoa(serverPort(t, srv)))
+	result := analyzeSingleSubdomain(context.Background(), sess, target, false, false)
+	if result.DNS == nil || result.DNS.Dangling || result.DNS.CNAME != "acme.github.io" {
+		t.Fatalf("records = %+v", result.DNS)
+	}
+	if result.Takeover == nil || result.Takeover.Service != "GitHub Pages" || result.Takeover.CNAME != "acme.github.io" {
+		t.Errorf("takeover = %+v", result.Takeover)
+	}
+}
@@ -0,0 +1,366 @@
+package modules
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnumSource discovers candidate subdomains of a root domain. Sources are
+// interfaces so that offline fixtures can stand in for live services.
+type EnumSource interface {
+	Name() string
+	Enumerate(ctx context.Context, domain string) ([]string, error)
+}
+
+// Enumeration source names accepted in enumSources[].
+const (
+	EnumSourceCT         = "ct"
+	EnumSourceZone       = "zone"
+	EnumSourceBruteForce = "bruteforce"
+	EnumSourceHistory    = "history"
+)
+
+//go:embed data/subdomains.txt
+var defaultWordlist string
+
+// EnumerateSubdomains runs every source against domain and returns the
+// deduplicated, sorted set of names inside the domain. A failing source is
+// logged and skipped so that the others still contribute.
+func EnumerateSubdomains(ctx context.Context, domain string, sources []EnumSource) []string {
+	domain = normalizeHostname(domain)
+	found := make(map[string]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src EnumSource) {
+			defer wg.Done()
+			names, err := src.Enumerate(ctx, domain)
+			if err != nil {
+				log.Printf("Enumeration source %s failed for %s: %v", src.Name(), domain, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, name := range names {
+				if name = normalizeHostname(name); inDomain(name, domain) {
+					found[name] = true
+				}
+			}
+		}(src)
+	}
+	wg.Wait()
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalizeHostname lowercases a name and strips wildcard labels and the
+// trailing root dot.
+func normalizeHostname(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimPrefix(name, "*.")
+	return strings.TrimSuffix(name, ".")
+}
+
+func inDomain(name, domain string) bool {
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// CTLogSource queries a certificate-transparency search service that returns
+// crt.sh style JSON ([{"name_value": "a.example.com\nb.example.com"}]).
+type CTLogSource struct {
+	BaseURL string // defaults to https://crt.sh
+	Client  *http.Client
+}
+
+func (s *CTLogSource) Name() string { return EnumSourceCT }
+
+func (s *CTLogSource) Enumerate(ctx context.Context, domain string) ([]string, error) {
+	base := s.BaseURL
+	if base == "" {
+		base = "https://crt.sh"
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	u := fmt.Sprintf("%s/?q=%s&output=json", strings.TrimSuffix(base, "/"), url.QueryEscape("%."+domain))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ParseCTLogJSON(resp.Body)
+}
+
+// ParseCTLogJSON extracts names from crt.sh style JSON entries.
+func ParseCTLogJSON(r io.Reader) ([]string, error) {
+	var entries []struct {
+		CommonName string `json:"common_name"`
+		NameValue  string `json:"name_value"`
+	}
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding certificate-transparency JSON: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.CommonName)
+		names = append(names, strings.Split(e.NameValue, "\n")...)
+	}
+	return names, nil
+}
+
+// ZoneFileSource extracts owner names and in-zone targets from a DNS zone
+// file in RFC 1035 master file format.
+type ZoneFileSource struct {
+	Data []byte
+}
+
+func (s *ZoneFileSource) Name() string { return EnumSourceZone }
+
+func (s *ZoneFileSource) Enumerate(ctx context.Context, domain string) ([]string, error) {
+	origin := domain
+	last := ""
+	var names []string
+	scanner := bufio.NewScanner(strings.NewReader(string(s.Data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if strings.EqualFold(fields[0], "$ORIGIN") && len(fields) > 1 {
+			origin = normalizeHostname(fields[1])
+			continue
+		}
+		if strings.HasPrefix(fields[0], "$") {
+			continue
+		}
+
+		// Lines starting with whitespace reuse the previous owner name.
+		owner := last
+		rest := fields
+		if line[0] != ' ' && line[0] != '\t' {
+			owner = qualifyName(fields[0], origin)
+			rest = fields[1:]
+		}
+		if owner == "" {
+			continue
+		}
+		last = owner
+		names = append(names, owner)
+
+		// Skip TTL and class to reach the record type and its data.
+		for len(rest) > 0 && (isNumeric(rest[0]) || isDNSClass(rest[0])) {
+			rest = rest[1:]
+		}
+		if len(rest) < 2 {
+			continue
+		}
+		switch strings.ToUpper(rest[0]) {
+		case "CNAME", "NS", "PTR":
+			names = append(names, qualifyName(rest[1], origin))
+		case "MX":
+			if len(rest) > 2 {
+				names = append(names, qualifyName(rest[2], origin))
+			}
+		case "SRV":
+			if len(rest) > 4 {
+				names = append(names, qualifyName(rest[4], origin))
+			}
+		}
+	}
+	return names, scanner.Err()
+}
+
+func qualifyName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return name
+	default:
+		return name + "." + origin
+	}
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDNSClass(s string) bool {
+	switch strings.ToUpper(s) {
+	case "IN", "CH", "HS", "CS":
+		return true
+	}
+	return false
+}
+
+// BruteForceSource resolves word.domain for every word of a wordlist and
+// reports the names that exist.
+type BruteForceSource struct {
+	Words       []string
+	Resolver    *net.Resolver
+	Concurrency int
+}
+
+func (s *BruteForceSource) Name() string { return EnumSourceBruteForce }
+
+func (s *BruteForceSource) Enumerate(ctx context.Context, domain string) ([]string, error) {
+	resolver := s.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	workers := s.Concurrency
+	if workers <= 0 {
+		workers = 20
+	}
+
+	// Under a wildcard every word resolves; keep only names whose answers
+	// differ from the wildcard's.
+	wildcard := detectWildcard(ctx, resolver, domain)
+
+	words := make(chan string)
+	var names []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for word := range words {
+				name := word + "." + domain
+				addrs, err := resolver.LookupHost(ctx, name)
+				if err == nil && len(addrs) > 0 && (wildcard == nil || !wildcard.matches(addrs, nil, "")) {
+					mu.Lock()
+					names = append(names, name)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+feed:
+	for _, word := range s.Words {
+		select {
+		case words <- word:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(words)
+	wg.Wait()
+	return names, ctx.Err()
+}
+
+// HistorySource reuses subdomains found by earlier jobs in the job store,
+// limited to the jobs User may see.
+type HistorySource struct {
+	User User
+}
+
+func (s *HistorySource) Name() string { return EnumSourceHistory }
+
+func (s *HistorySource) Enumerate(ctx context.Context, domain string) ([]string, error) {
+	jobs, err := getStore().ListJobs()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, job := range jobs {
+		if job.Kind != JobKindSubdomain || !userCanAccess(s.User, job.Owner) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return names, err
+		}
+		results, ok := GetSubdomainResults(job.ID)
+		if !ok {
+			continue
+		}
+		for _, r := range results {
+			names = append(names, r.Subdomain)
+		}
+	}
+	return names, nil
+}
+
+// EnumOptions holds the inputs needed to build the enumeration sources of a
+// subdomain job.
+type EnumOptions struct {
+	Sources   []string // source names, defaults to ct, history and bruteforce
+	Resolvers []string
+	Wordlist  []byte // optional, replaces the bundled wordlist
+	ZoneFile  []byte // enables the zone source when set
+	User      User   // the history source only reuses jobs this user may see
+}
+
+// BuildEnumSources turns EnumOptions into sources. Unknown names are an error.
+func BuildEnumSources(opts EnumOptions) ([]EnumSource, error) {
+	names := opts.Sources
+	if len(names) == 0 {
+		names = []string{EnumSourceCT, EnumSourceHistory, EnumSourceBruteForce}
+		if len(opts.ZoneFile) > 0 {
+			names = append(names, EnumSourceZone)
+		}
+	}
+	var sources []EnumSource
+	for _, name := range names {
+		switch name {
+		case EnumSourceCT:
+			sources = append(sources, &CTLogSource{})
+		case EnumSourceHistory:
+			sources = append(sources, &HistorySource{User: opts.User})
+		case EnumSourceZone:
+			if len(opts.ZoneFile) == 0 {
+				return nil, fmt.Errorf("enumeration source %q needs a zone file", name)
+			}
+			sources = append(sources, &ZoneFileSource{Data: opts.ZoneFile})
+		case EnumSourceBruteForce:
+			wordlist := defaultWordlist
+			if len(opts.Wordlist) > 0 {
+				wordlist = string(opts.Wordlist)
+			}
+			sources = append(sources, &BruteForceSource{
+				Words:    parseSubdomainsFromText(wordlist),
+				Resolver: newNetResolver(opts.Resolvers),
+			})
+		default:
+			return nil, fmt.Errorf("unknown enumeration source %q", name)
+		}
+	}
+	return sources, nil
+}
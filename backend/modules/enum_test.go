@@ -0,0 +1,152 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const ctFixture = `[
+	{"common_name": "example.com", "name_value": "example.com\nwww.example.com"},
+	{"common_name": "*.api.example.com", "name_value": "*.api.example.com\nAPI.example.com."},
+	{"common_name": "evil.org", "name_value": "evil.org"}
+]`
+
+const zoneFixture = `$ORIGIN example.com.
+$TTL 3600
+@	IN	SOA	ns1 hostmaster 1 7200 900 1209600 300
+	IN	NS	ns1
+	IN	MX	10 mail
+ns1	IN	A	192.0.2.1
+mail	3600	IN	A	192.0.2.2
+www	IN	CNAME	web.cdn.example.net.
+_sip._tcp	IN	SRV	10 5 5060 voip ; comment
+`
+
+type fixtureSource struct {
+	name  string
+	names []string
+	err   error
+}
+
+func (s *fixtureSource) Name() string { return s.name }
+
+func (s *fixtureSource) Enumerate(ctx context.Context, domain string) ([]string, error) {
+	return s.names, s.err
+}
+
+func TestCTLogSource(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		w.Write([]byte(ctFixture))
+	}))
+	defer srv.Close()
+
+	src := &CTLogSource{BaseURL: srv.URL, Client: srv.Client()}
+	got := EnumerateSubdomains(context.Background(), "example.com", []EnumSource{src})
+	want := []string{"api.example.com", "example.com", "www.example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+	if query != "%.example.com" {
+		t.Errorf("query = %q", query)
+	}
+}
+
+func TestCTLogSourceError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	src := &CTLogSource{BaseURL: srv.URL, Client: srv.Client()}
+	if _, err := src.Enumerate(context.Background(), "example.com"); err == nil {
+		t.Error("expected an error for a 502 response")
+	}
+}
+
+func TestZoneFileSource(t *testing.T) {
+	src := &ZoneFileSource{Data: []byte(zoneFixture)}
+	got := EnumerateSubdomains(context.Background(), "example.com", []EnumSource{src})
+	want := []string{"_sip._tcp.example.com", "example.com", "mail.example.com", "ns1.example.com", "voip.example.com", "www.example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+}
+
+func TestHistorySource(t *testing.T) {
+	SetStore(NewMemoryStore())
+	job := createJob(JobKindSubdomain, "alice", []string{"a.example.com"}, nil)
+	StoreSubdomainResults(job.ID, []AnalysisResult{{Subdomain: "a.example.com"}, {Subdomain: "b.example.com"}})
+	job = createJob(JobKindSubdomain, "bob", []string{"secret.example.com"}, nil)
+	StoreSubdomainResults(job.ID, []AnalysisResult{{Subdomain: "secret.example.com"}})
+	job = createJob(JobKindSubdomain, "", []string{"legacy.example.com"}, nil)
+	StoreSubdomainResults(job.ID, []AnalysisResult{{Subdomain: "legacy.example.com"}})
+	createJob(JobKindURL, "alice", []string{"https://c.example.com"}, nil)
+
+	tests := []struct {
+		user User
+		want []string
+	}{
+		{User{Username: "alice", Role: RoleUser}, []string{"a.example.com", "b.example.com"}},
+		{User{Username: "bob", Role: RoleUser}, []string{"secret.example.com"}},
+		{User{Username: "carol", Role: RoleUser}, nil},
+		{User{}, nil},
+		{User{Username: "root", Role: RoleAdmin}, []string{"a.example.com", "b.example.com", "legacy.example.com", "secret.example.com"}},
+	}
+	for _, tt := range tests {
+		names, err := (&HistorySource{User: tt.user}).Enumerate(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%q: names = %v, want %v", tt.user.Username, names, tt.want)
+		}
+	}
+
+	sources, err := BuildEnumSources(EnumOptions{Sources: []string{EnumSourceHistory}, User: User{Username: "bob", Role: RoleUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := sources[0].Enumerate(context.Background(), "example.com"); !reflect.DeepEqual(names, []string{"secret.example.com"}) {
+		t.Errorf("built source: names = %v", names)
+	}
+}
+
+func TestEnumerateSubdomainsMergesSources(t *testing.T) {
+	sources := []EnumSource{
+		&fixtureSource{name: "a", names: []string{"www.example.com", "other.org"}},
+		&fixtureSource{name: "b", names: []string{"WWW.example.com.", "dev.example.com"}, err: errors.New("partial")},
+		&fixtureSource{name: "c", err: errors.New("down")},
+	}
+	got := EnumerateSubdomains(context.Background(), "example.com", sources)
+	if want := []string{"dev.example.com", "www.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("names = %v, want %v", got, want)
+	}
+}
+
+func TestBuildEnumSources(t *testing.T) {
+	if _, err := BuildEnumSources(EnumOptions{Sources: []string{"nope"}}); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+	if _, err := BuildEnumSources(EnumOptions{Sources: []string{EnumSourceZone}}); err == nil {
+		t.Error("expected an error for the zone source without a zone file")
+	}
+	sources, err := BuildEnumSources(EnumOptions{ZoneFile: []byte(zoneFixture)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range sources {
+		names = append(names, s.Name())
+	}
+	if want := []string{EnumSourceCT, EnumSourceHistory, EnumSourceBruteForce, EnumSourceZone}; !reflect.DeepEqual(names, want) {
+		t.Errorf("sources = %v, want %v", names, want)
+	}
+}
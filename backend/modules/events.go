@@ -0,0 +1,180 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseHeartbeat is how often an idle SSE stream sends a comment, so that
+// proxies do not time the connection out.
+const sseHeartbeat = 25 * time.Second
+
+// maxPollWait bounds the ?wait of the polling endpoint.
+const maxPollWait = 60 * time.Second
+
+// JobProgress is the response of the polling endpoint.
+type JobProgress struct {
+	JobID    string     `json:"jobId"`
+	Status   string     `json:"status"`
+	Progress int        `json:"progress"`
+	LastSeq  int        `json:"lastSeq"` // pass as ?since on the next poll
+	Done     bool       `json:"done"`    // the job ended and every event was returned
+	Events   []JobEvent `json:"events"`
+}
+
+// HandleJobEvents streams the events of a job as Server-Sent Events, each
+// with its seq as the event id. Browsers that reconnect send it back in
+// Last-Event-ID; other clients can pass ?since=N. The stream ends after the
+// final event.
+func HandleJobEvents(c *gin.Context) {
+	job, ok := lookupJob(c)
+	if !ok {
+		return
+	}
+	since, ok := sinceParam(c, c.GetHeader("Last-Event-ID"))
+	if !ok {
+		return
+	}
+
+	client := newEventQueue()
+	backlog := subscribe(job.ID, since, client)
+	defer removeClient(job.ID, client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // disable response buffering in nginx
+	c.Status(http.StatusOK)
+
+	for _, ev := range backlog {
+		if writeSSE(c, ev) != nil || ev.Final {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, open := <-client.events:
+			if !open {
+				return // fell behind; the client reconnects from its last id
+			}
+			if writeSSE(c, ev) != nil || ev.Final {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeSSE(c *gin.Context, ev JobEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+	return err
+}
+
+// HandleJobProgress returns the status of a job and its events after
+// ?since=N. With ?wait=<seconds> it long-polls: if there are no new events
+// yet it waits for the next one, up to the given time.
+func HandleJobProgress(c *gin.Context) {
+	job, ok := lookupJob(c)
+	if !ok {
+		return
+	}
+	since, ok := sinceParam(c, "")
+	if !ok {
+		return
+	}
+	var wait time.Duration
+	if v := c.Query("wait"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wait parameter"})
+			return
+		}
+		wait = time.Duration(n) * time.Second
+		if wait > maxPollWait {
+			wait = maxPollWait
+		}
+	}
+
+	client := newEventQueue()
+	events := subscribe(job.ID, since, client)
+	if len(events) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case ev, open := <-client.events:
+			if open {
+				events = append(events, ev)
+			}
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+		}
+		timer.Stop()
+	}
+	removeClient(job.ID, client)
+	// Events published while the first was being received.
+drain:
+	for {
+		select {
+		case ev, open := <-client.events:
+			if !open {
+				break drain
+			}
+			events = append(events, ev)
+		default:
+			break drain
+		}
+	}
+
+	if updated, err := getStore().GetJob(job.ID); err == nil {
+		job = updated
+	}
+	job = liveJob(job)
+	resp := JobProgress{JobID: job.ID, Status: job.Status, Progress: percent(job.Processed, job.Total), LastSeq: since, Events: events}
+	if resp.Events == nil {
+		resp.Events = []JobEvent{}
+	}
+	if n := len(events); n > 0 {
+		resp.LastSeq = events[n-1].Seq
+		resp.Progress = events[n-1].Progress
+		resp.Done = events[n-1].Final
+	} else if job.Status != JobStatusRunning && job.Status != JobStatusPaused {
+		resp.Done = true
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// sinceParam reads the seq a client resumes from: fallback if set (such as
+// Last-Event-ID), otherwise ?since. It writes a 400 response if invalid.
+func sinceParam(c *gin.Context, fallback string) (int, bool) {
+	v := fallback
+	if v == "" {
+		v = c.Query("since")
+	}
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since parameter"})
+		return 0, false
+	}
+	return n, true
+}
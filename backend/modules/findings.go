@@ -0,0 +1,123 @@
+package modules
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Finding severities, from least to most severe.
+const (
+	SeverityInfo     = "info"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+var severities = []string{SeverityInfo, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// Finding confidences.
+const (
+	ConfidenceLow    = "low"
+	ConfidenceMedium = "medium"
+	ConfidenceHigh   = "high"
+)
+
+var confidences = []string{ConfidenceLow, ConfidenceMedium, ConfidenceHigh}
+
+// Finding is one issue found on a target. The priority of the target is
+// that of its most severe finding.
+type Finding struct {
+	ID          string   `json:"ID"` // stable across scans of the same target
+	Title       string   `json:"Title"`
+	Severity    string   `json:"Severity"`
+	Confidence  string   `json:"Confidence"`
+	Evidence    string   `json:"Evidence"` // excerpt of the request or response that shows the issue
+	Location    string   `json:"Location"` // URL, file or DNS name
+	RuleID      string   `json:"RuleID"`
+	Remediation string   `json:"Remediation"`
+	References  []string `json:"References"`
+}
+
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// severityPriority maps a severity to the priority of a target.
+func severityPriority(severity string) string {
+	switch severity {
+	case SeverityCritical, SeverityHigh:
+		return "High"
+	case SeverityMedium:
+		return "Medium"
+	}
+	return "Low"
+}
+
+// raisePriority returns the higher of two priorities.
+func raisePriority(current, p string) string {
+	order := map[string]int{"Low": 0, "Medium": 1, "High": 2}
+	if order[p] > order[current] {
+		return p
+	}
+	return current
+}
+
+// findingsPriority returns the priority of a target with these findings.
+func findingsPriority(findings []Finding) string {
+	priority := "Low"
+	for _, f := range findings {
+		priority = raisePriority(priority, severityPriority(f.Severity))
+	}
+	return priority
+}
+
+// appendFinding assigns f its ID and appends it unless it was already found.
+func appendFinding(findings []Finding, f Finding) []Finding {
+	sum := sha1.Sum([]byte(f.Location + "\x00" + f.Evidence))
+	f.ID = f.RuleID + "-" + hex.EncodeToString(sum[:5])
+	for _, existing := range findings {
+		if existing.ID == f.ID {
+			return findings
+		}
+	}
+	return append(findings, f)
+}
+
+// sortFindings orders findings from the most to the least severe.
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		if a, b := severityRank(findings[i].Severity), severityRank(findings[j].Severity); a != b {
+			return a > b
+		}
+		return findings[i].Title < findings[j].Title
+	})
+}
+
+func (r *AnalysisResult) addFinding(f Finding) {
+	r.Findings = appendFinding(r.Findings, f)
+	sortFindings(r.Findings)
+	r.Priority = findingsPriority(r.Findings)
+}
+
+func writeFindings(b *strings.Builder, findings []Finding) {
+	b.WriteString("\nFindings:\n")
+	for _, f := range findings {
+		fmt.Fprintf(b, "- [%s] %s (%s confidence)\n", f.Severity, f.Title, f.Confidence)
+		fmt.Fprintf(b, "  Location: %s\n", f.Location)
+		if f.Evidence != "" {
+			fmt.Fprintf(b, "  Evidence: %s\n", f.Evidence)
+		}
+		if f.Remediation != "" {
+			fmt.Fprintf(b, "  Remediation: %s\n", f.Remediation)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsProbeOrigin is sent to find servers that reflect any Origin. It must
+// never be a real site.
+const corsProbeOrigin = "https://cors-probe.invalid"
+
+// hstsMinMaxAge is the shortest HSTS lifetime that is not flagged, 180 days.
+const hstsMinMaxAge = 15552000
+
+const mdnHeaders = "https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/"
+
+// auditHeaders checks the security headers and cookies of resp and probes
+// the CORS policy of its URL with forged origins.
+func auditHeaders(ctx context.Context, sess *scanSession, resp *http.Response) []Finding {
+	a := headerAudit{page: resp.Request.URL.String()}
+	h := resp.Header
+	isHTML := strings.Contains(strings.ToLower(h.Get("Content-Type")), "html")
+
+	csp := parseCSP(h.Get("Content-Security-Policy"))
+	if isHTML {
+		a.checkCSP(h, csp)
+		a.checkFraming(h, csp)
+		if h.Get("Permissions-Policy") == "" && h.Get("Feature-Policy") == "" {
+			a.add("permissions-policy-missing", "Permissions-Policy header missing", SeverityInfo, ConfidenceHigh, "no Permissions-Policy header",
+				"Disable the browser features the site does not use, e.g. Permissions-Policy: camera=(), microphone=(), geolocation=().", "Permissions-Policy")
+		}
+	}
+	if resp.Request.URL.Scheme == "https" {
+		a.checkHSTS(h.Get("Strict-Transport-Security"))
+	}
+	if v := h.Get("X-Content-Type-Options"); !strings.EqualFold(strings.TrimSpace(v), "nosniff") {
+		a.add("x-content-type-options", "X-Content-Type-Options is not nosniff", SeverityLow, ConfidenceHigh, headerEvidence("X-Content-Type-Options", v),
+			"Send X-Content-Type-Options: nosniff so that browsers do not guess content types.", "X-Content-Type-Options")
+	}
+	a.checkReferrerPolicy(h.Get("Referrer-Policy"))
+	a.checkCookies(resp)
+	a.probeCORS(ctx, sess, resp.Request.URL.String())
+	return a.findings
+}
+
+type headerAudit struct {
+	page     string
+	findings []Finding
+}
+
+func (a *headerAudit) add(ruleID, title, severity, confidence, evidence, remediation, reference string) {
+	a.findings = appendFinding(a.findings, Finding{
+		Title:       title,
+		Severity:    severity,
+		Confidence:  confidence,
+		Evidence:    evidence,
+		Location:    a.page,
+		RuleID:      ruleID,
+		Remediation: remediation,
+		References:  []string{mdnHeaders + reference},
+	})
+}
+
+func headerEvidence(name, value string) string {
+	if value == "" {
+		return "no " + name + " header"
+	}
+	return name + ": " + value
+}
+
+// parseCSP returns the source lists of a Content-Security-Policy by
+// lowercased directive name. Only the first policy of the header counts.
+func parseCSP(header string) map[string][]string {
+	if header == "" {
+		return nil
+	}
+	header = strings.SplitN(header, ",", 2)[0]
+	csp := make(map[string][]string)
+	for _, directive := range strings.Split(header, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, dup := csp[name]; !dup {
+			csp[name] = fields[1:]
+		}
+	}
+	return csp
+}
+
+func (a *headerAudit) checkCSP(h http.Header, csp map[string][]string) {
+	if csp == nil {
+		evidence := "no Content-Security-Policy header"
+		if ro := h.Get("Content-Security-Policy-Report-Only"); ro != "" {
+			evidence = "only Content-Security-Policy-Report-Only: " + ro
+		}
+		a.add("csp-missing", "Content-Security-Policy missing", SeverityLow, ConfidenceHigh, evidence,
+			"Define a Content-Security-Policy that restricts script sources, starting with default-src 'self'.", "Content-Security-Policy")
+		return
+	}
+
+	sources, ok := csp["script-src"]
+	directive := "script-src"
+	if !ok {
+		sources, ok = csp["default-src"]
+		directive = "default-src"
+	}
+	var issues []string
+	if !ok {
+		issues = append(issues, "no script-src or default-src")
+	}
+	nonced := false
+	for _, s := range sources {
+		s = strings.ToLower(s)
+		if strings.HasPrefix(s, "'nonce-") || strings.HasPrefix(s, "'sha") {
+			nonced = true
+		}
+	}
+	for _, s := range sources {
+		switch s = strings.ToLower(s); s {
+		case "'unsafe-inline'":
+			if !nonced { // ignored by browsers when a nonce or hash is present
+				issues = append(issues, directive+" allows 'unsafe-inline'")
+			}
+		case "'unsafe-eval'":
+			issues = append(issues, directive+" allows 'unsafe-eval'")
+		case "*", "http:", "https:", "data:":
+			issues = append(issues, fmt.Sprintf("%s allows any %s source", directive, strings.TrimSuffix(s, ":")))
+		}
+	}
+	if len(issues) > 0 {
+		a.add("csp-weak", "Weak Content-Security-Policy", SeverityLow, ConfidenceMedium, strings.Join(issues, "; "),
+			"Remove 'unsafe-inline', 'unsafe-eval' and wildcard sources from script-src; use nonces or hashes for inline scripts.", "Content-Security-Policy")
+	}
+}
+
+// checkFraming looks for clickjacking protection, which frame-ancestors
+// provides in preference to X-Frame-Options.
+func (a *headerAudit) checkFraming(h http.Header, csp map[string][]string) {
+	if _, ok := csp["frame-ancestors"]; ok {
+		return
+	}
+	xfo := strings.ToUpper(strings.TrimSpace(h.Get("X-Frame-Options")))
+	switch xfo {
+	case "DENY", "SAMEORIGIN":
+		return
+	case "":
+		a.add("clickjacking", "No clickjacking protection", SeverityLow, ConfidenceHigh, "no X-Frame-Options header or frame-ancestors directive",
+			"Send Content-Security-Policy: frame-ancestors 'self' (or X-Frame-Options: DENY).", "X-Frame-Options")
+	default:
+		a.add("x-frame-options-invalid", "Invalid X-Frame-Options", SeverityLow, ConfidenceHigh, headerEvidence("X-Frame-Options", h.Get("X-Frame-Options")),
+			"Use DENY or SAMEORIGIN; ALLOW-FROM is not supported by current browsers, use frame-ancestors instead.", "X-Frame-Options")
+	}
+}
+
+func (a *headerAudit) checkHSTS(value string) {
+	if value == "" {
+		a.add("hsts-missing", "Strict-Transport-Security missing", SeverityLow, ConfidenceHigh, "no Strict-Transport-Security header on an HTTPS response",
+			fmt.Sprintf("Send Strict-Transport-Security: max-age=%d; includeSubDomains; preload.", 2*hstsMinMaxAge), "Strict-Transport-Security")
+		return
+	}
+	maxAge := -1
+	var subdomains, preload bool
+	for _, d := range strings.Split(value, ";") {
+		d = strings.TrimSpace(d)
+		switch {
+		case strings.HasPrefix(strings.ToLower(d), "max-age="):
+			if n, err := strconv.Atoi(strings.Trim(d[len("max-age="):], `"`)); err == nil {
+				maxAge = n
+			}
+		case strings.EqualFold(d, "includeSubDomains"):
+			subdomains = true
+		case strings.EqualFold(d, "preload"):
+			preload = true
+		}
+	}
+
+	var issues []string
+	severity := SeverityInfo
+	if maxAge < hstsMinMaxAge {
+		issues = append(issues, fmt.Sprintf("max-age below %d seconds", hstsMinMaxAge))
+		severity = SeverityLow
+	}
+	if !subdomains {
+		issues = append(issues, "no includeSubDomains")
+	}
+	if !preload {
+		issues = append(issues, "no preload")
+	}
+	if len(issues) > 0 {
+		a.add("hsts-weak", "Weak Strict-Transport-Security", severity, ConfidenceHigh, "Strict-Transport-Security: "+value+" ("+strings.Join(issues, ", ")+")",
+			fmt.Sprintf("Use a max-age of at least %d seconds with includeSubDomains, and submit the domain to the preload list.", hstsMinMaxAge), "Strict-Transport-Security")
+	}
+}
+
+func (a *headerAudit) checkReferrerPolicy(value string) {
+	if value == "" {
+		a.add("referrer-policy-missing", "Referrer-Policy missing", SeverityInfo, ConfidenceHigh, "no Referrer-Policy header",
+			"Send Referrer-Policy: strict-origin-when-cross-origin or stricter.", "Referrer-Policy")
+		return
+	}
+	// Browsers apply the last policy they understand.
+	policies := strings.Split(value, ",")
+	switch strings.ToLower(strings.TrimSpace(policies[len(policies)-1])) {
+	case "unsafe-url", "no-referrer-when-downgrade":
+		a.add("referrer-policy-weak", "Referrer-Policy leaks full URLs", SeverityLow, ConfidenceHigh, headerEvidence("Referrer-Policy", value),
+			"Use strict-origin-when-cross-origin or stricter so that paths and query strings are not sent to other sites.", "Referrer-Policy")
+	}
+}
+
+func (a *headerAudit) checkCookies(resp *http.Response) {
+	https := resp.Request.URL.Scheme == "https"
+	for _, c := range resp.Cookies() {
+		var missing []string
+		severity := SeverityLow
+		if !c.Secure && (https || c.SameSite == http.SameSiteNoneMode) {
+			missing = append(missing, "Secure")
+			if c.SameSite == http.SameSiteNoneMode {
+				severity = SeverityMedium // browsers drop SameSite=None cookies without Secure
+			}
+		}
+		if !c.HttpOnly {
+			missing = append(missing, "HttpOnly")
+		}
+		if c.SameSite == http.SameSiteDefaultMode {
+			missing = append(missing, "SameSite")
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		a.add("cookie-flags", "Cookie "+c.Name+" lacks "+strings.Join(missing, ", "), severity, ConfidenceHigh, "Set-Cookie: "+c.Name+"="+redactSecret(c.Value),
+			"Set the Secure, HttpOnly and SameSite attributes on cookies, in particular session cookies.", "Set-Cookie")
+	}
+}
+
+// probeCORS requests target with a forged Origin, then with the null origin
+// sent by sandboxed frames, and reports servers that trust either.
+func (a *headerAudit) probeCORS(ctx context.Context, sess *scanSession, target string) {
+	for _, origin := range []string{corsProbeOrigin, "null"} {
+		req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+		if err != nil {
+			return
+		}
+		req.Header.Set("Origin", origin)
+		resp, err := sess.client.Do(req)
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+
+		allowed := resp.Header.Get("Access-Control-Allow-Origin")
+		if allowed != origin {
+			continue
+		}
+		credentials := strings.EqualFold(resp.Header.Get("Access-Control-Allow-Credentials"), "true")
+		evidence := fmt.Sprintf("Origin: %s -> Access-Control-Allow-Origin: %s", origin, allowed)
+		severity, title := SeverityLow, "CORS trusts the "+origin+" origin"
+		if origin == corsProbeOrigin {
+			title = "CORS reflects arbitrary origins"
+		}
+		if credentials {
+			evidence += ", Access-Control-Allow-Credentials: true"
+			severity = SeverityHigh
+			title += " with credentials"
+		}
+		a.add("cors-misconfiguration", title, severity, ConfidenceHigh, evidence,
+			"Only allow trusted origins from an explicit list, never the null origin, and only send Access-Control-Allow-Credentials to those.", "Access-Control-Allow-Origin")
+		return
+	}
+}
@@ -0,0 +1,354 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errJobCancelled  = errors.New("job cancelled")
+	errJobDeadline   = errors.New("job deadline exceeded")
+	errServerStopped = errors.New("server shutting down")
+)
+
+// jobRuntime is the in-memory control block of a job that is currently
+// executing in this process.
+type jobRuntime struct {
+	cancel    context.CancelCauseFunc
+	stop      context.CancelFunc
+	done      chan struct{}
+	resume    chan struct{} // non-nil while paused, closed on resume
+	state     string
+	processed int
+}
+
+var (
+	activeJobs   = make(map[string]*jobRuntime) // jobID -> runtime
+	activeJobsMu sync.Mutex
+)
+
+// startJobRuntime registers a running job and returns the context every
+// network operation of the job must use. A zero timeout means no deadline.
+// finishJobRuntime must be called when the job exits.
+func startJobRuntime(jobID string, timeout time.Duration) context.Context {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	stop := func() {}
+	if timeout > 0 {
+		ctx, stop = context.WithTimeoutCause(ctx, timeout, errJobDeadline)
+	}
+	activeJobsMu.Lock()
+	defer activeJobsMu.Unlock()
+	activeJobs[jobID] = &jobRuntime{cancel: cancel, stop: stop, done: make(chan struct{}), state: JobStatusRunning}
+	return ctx
+}
+
+func finishJobRuntime(jobID string) {
+	activeJobsMu.Lock()
+	defer activeJobsMu.Unlock()
+	if rt, ok := activeJobs[jobID]; ok {
+		rt.stop()
+		rt.cancel(nil)
+		close(rt.done)
+		delete(activeJobs, jobID)
+	}
+}
+
+// parseJobTimeout reads the optional per-job deadline, given in seconds.
+func parseJobTimeout(seconds string) time.Duration {
+	n, err := strconv.Atoi(seconds)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// jobEndStatus maps the state of a job context to the status it finishes with.
+func jobEndStatus(ctx context.Context) string {
+	switch context.Cause(ctx) {
+	case nil:
+		return JobStatusCompleted
+	case errServerStopped:
+		return JobStatusInterrupted
+	case errJobDeadline:
+		return JobStatusTimedOut
+	default:
+		return JobStatusCancelled
+	}
+}
+
+// waitIfPaused blocks while the job is paused. It returns early with the
+// context error if the job is cancelled in the meantime.
+func waitIfPaused(ctx context.Context, jobID string) error {
+	activeJobsMu.Lock()
+	var resume chan struct{}
+	if rt, ok := activeJobs[jobID]; ok {
+		resume = rt.resume
+	}
+	activeJobsMu.Unlock()
+	if resume == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-resume:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func setJobProcessed(jobID string, processed int) {
+	activeJobsMu.Lock()
+	defer activeJobsMu.Unlock()
+	if rt, ok := activeJobs[jobID]; ok {
+		rt.processed = processed
+	}
+}
+
+// setJobState switches a running job between running and paused. It returns
+// false if the job is not executing in this process.
+func setJobState(jobID, state string) bool {
+	activeJobsMu.Lock()
+	rt, ok := activeJobs[jobID]
+	if ok && rt.state != state {
+		rt.state = state
+		if state == JobStatusPaused {
+			rt.resume = make(chan struct{})
+		} else if rt.resume != nil {
+			close(rt.resume)
+			rt.resume = nil
+		}
+	}
+	activeJobsMu.Unlock()
+	if !ok {
+		return false
+	}
+	// The job may have finished since the runtime was looked up; its final
+	// status must not be replaced.
+	running := false
+	updateJob(jobID, func(job *Job) {
+		if running = job.FinishedAt == nil && !rt.finished(); running {
+			job.Status = state
+		}
+	})
+	if !running {
+		return false
+	}
+	BroadcastJobState(jobID, state)
+	return true
+}
+
+func (rt *jobRuntime) finished() bool {
+	select {
+	case <-rt.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// cancelJob stops a running job and waits for its workers to exit.
+func cancelJob(jobID string) bool {
+	activeJobsMu.Lock()
+	rt, ok := activeJobs[jobID]
+	activeJobsMu.Unlock()
+	if !ok {
+		return false
+	}
+	rt.cancel(errJobCancelled)
+	<-rt.done
+	return true
+}
+
+// ShutdownJobs aborts every running job and waits for them to record their
+// partial results. Interrupted jobs are stored with the interrupted status.
+func ShutdownJobs() {
+	activeJobsMu.Lock()
+	running := make([]*jobRuntime, 0, len(activeJobs))
+	for _, rt := range activeJobs {
+		running = append(running, rt)
+	}
+	activeJobsMu.Unlock()
+	for _, rt := range running {
+		rt.cancel(errServerStopped)
+	}
+	for _, rt := range running {
+		<-rt.done
+	}
+}
+
+// liveJob overlays the in-memory progress of a running job onto its stored record.
+func liveJob(job Job) Job {
+	activeJobsMu.Lock()
+	defer activeJobsMu.Unlock()
+	if rt, ok := activeJobs[job.ID]; ok {
+		job.Status = rt.state
+		job.Processed = rt.processed
+	}
+	return job
+}
+
+// JobCounts summarises the results of a job.
+type JobCounts struct {
+	Total     int `json:"total"`
+	Processed int `json:"processed"`
+	Reachable int `json:"reachable"`
+	High      int `json:"high"`
+	Medium    int `json:"medium"`
+	Low       int `json:"low"`
+}
+
+type JobDetails struct {
+	Job
+	Progress int       `json:"progress"`
+	Counts   JobCounts `json:"counts"`
+}
+
+func jobDetails(job Job) JobDetails {
+	job = liveJob(job)
+	details := JobDetails{Job: job}
+	details.Counts.Total = job.Total
+	details.Counts.Processed = job.Processed
+	if job.Total > 0 {
+		details.Progress = (job.Processed * 100) / job.Total
+	}
+
+	var priorities []string
+	switch job.Kind {
+	case JobKindSubdomain:
+		if results, ok := GetSubdomainResults(job.ID); ok {
+			for _, r := range results {
+				if r.IsReachable {
+					details.Counts.Reachable++
+				}
+				priorities = append(priorities, r.Priority)
+			}
+		}
+	case JobKindURL:
+		if results, ok := GetURLResults(job.ID); ok {
+			for _, r := range results {
+				if r.IsReachable {
+					details.Counts.Reachable++
+				}
+				priorities = append(priorities, r.Priority)
+			}
+		}
+	}
+	for _, p := range priorities {
+		switch p {
+		case "High":
+			details.Counts.High++
+		case "Medium":
+			details.Counts.Medium++
+		default:
+			details.Counts.Low++
+		}
+	}
+	return details
+}
+
+// --- API Handlers for Job Lifecycle ---
+
+func HandleListJobs(c *gin.Context) {
+	jobs, err := getStore().ListJobs()
+	if err != nil {
+		log.Printf("Error listing jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list jobs"})
+		return
+	}
+	visible := []Job{}
+	for _, job := range jobs {
+		if canAccess(c, job.Owner) {
+			visible = append(visible, liveJob(job))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": visible})
+}
+
+func HandleGetJob(c *gin.Context) {
+	job, ok := lookupJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, jobDetails(job))
+}
+
+func HandlePauseJob(c *gin.Context) {
+	handleJobStateChange(c, JobStatusPaused)
+}
+
+func HandleResumeJob(c *gin.Context) {
+	handleJobStateChange(c, JobStatusRunning)
+}
+
+func handleJobStateChange(c *gin.Context, state string) {
+	job, ok := lookupJob(c)
+	if !ok {
+		return
+	}
+	if !setJobState(job.ID, state) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is not running", "status": job.Status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": state})
+}
+
+func HandleCancelJob(c *gin.Context) {
+	job, ok := lookupJob(c)
+	if !ok {
+		return
+	}
+	if !cancelJob(job.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is not running", "status": job.Status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": JobStatusCancelled})
+}
+
+func HandleDeleteJob(c *gin.Context) {
+	job, ok := lookupJob(c)
+	if !ok {
+		return
+	}
+	cancelJob(job.ID)
+	dropEventLog(job.ID)
+	if err := getStore().DeleteJob(job.ID); err != nil && !errors.Is(err, ErrJobNotFound) {
+		log.Printf("Error deleting job %s: %v", job.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete job"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// lookupJob loads the job named by the :jobID route parameter, writing a 404
+// response if it does not exist.
+func lookupJob(c *gin.Context) (Job, bool) {
+	return lookupJobParam(c, "jobID")
+}
+
+// LookupJob is lookupJob for handlers outside the package, such as the
+// WebSocket endpoint, that must check the job exists and belongs to the user.
+func LookupJob(c *gin.Context) (Job, bool) {
+	return lookupJob(c)
+}
+
+// lookupJobParam is lookupJob for the job named by another route parameter.
+func lookupJobParam(c *gin.Context, param string) (Job, bool) {
+	job, err := getStore().GetJob(c.Param(param))
+	if errors.Is(err, ErrJobNotFound) || (err == nil && !canAccess(c, job.Owner)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return Job{}, false
+	}
+	if err != nil {
+		log.Printf("Error loading job %s: %v", c.Param(param), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load job"})
+		return Job{}, false
+	}
+	return job, true
+}
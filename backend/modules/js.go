@@ -0,0 +1,405 @@
+package modules
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSAnalysisResult holds what was extracted from one script and its source
+// map.
+type JSAnalysisResult struct {
+	URL         string        `json:"URL"`
+	Source      string        `json:"Source"` // page that loaded the script, if found by a crawl
+	IsReachable bool          `json:"IsReachable"`
+	StatusCode  int           `json:"StatusCode"`
+	Size        int           `json:"Size"`
+	SourceMap   string        `json:"SourceMap"`   // source map that was followed
+	SourceFiles []string      `json:"SourceFiles"` // original files listed by the source map
+	Paths       []string      `json:"Paths"`       // API paths and routes
+	URLs        []string      `json:"URLs"`        // absolute URLs
+	GraphQL     []string      `json:"GraphQL"`     // operations, e.g. "query GetUser"
+	Buckets     []string      `json:"Buckets"`     // cloud storage, e.g. "s3:assets-prod"
+	Secrets     []SecretMatch `json:"Secrets"`     // located in the beautified script, or in the original source file
+	Beautified  string        `json:"Beautified,omitempty"`
+	Error       string        `json:"Error"`
+}
+
+type JSAnalysisRequest struct {
+	URLs     []string `json:"urls" form:"urls[]"`
+	Content  string   `json:"content" form:"content"`   // script source to analyse instead of downloading
+	Beautify bool     `json:"beautify" form:"beautify"` // include the beautified source in the results
+}
+
+const (
+	maxScriptSize    = 5 << 20
+	maxSourceMapSize = 20 << 20
+	maxJSRequestURLs = 100
+)
+
+func HandleJSAnalysis(c *gin.Context) {
+	var req JSAnalysisRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if content, err := formFileBytes(c, "file"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read file"})
+		return
+	} else if content != nil {
+		req.Content = string(content)
+	}
+	if len(req.URLs) == 0 && req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No script URLs or content provided"})
+		return
+	}
+	if len(req.URLs) > maxJSRequestURLs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d script URLs per request", maxJSRequestURLs)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	sess := newScanSession(cfg.Scan.RequestsPerSecond, cfg.Scan.PerHostRPS)
+	defer sess.close()
+	results := []JSAnalysisResult{}
+	if req.Content != "" {
+		result := JSAnalysisResult{URL: "inline", IsReachable: true, Size: len(req.Content)}
+		analyzeScript(&result, sess.secrets, "inline", req.Content, req.Beautify)
+		results = append(results, result)
+	}
+	for _, u := range req.URLs {
+		if ctx.Err() != nil {
+			return // client went away
+		}
+		results = append(results, analyzeScriptURL(ctx, sess, strings.TrimSpace(u), req.Beautify))
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// analyzeScriptURL downloads a script, extracts its findings and follows its
+// source map.
+func analyzeScriptURL(ctx context.Context, sess *scanSession, scriptURL string, beautify bool) JSAnalysisResult {
+	result := JSAnalysisResult{URL: scriptURL}
+	u, err := url.Parse(scriptURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		result.Error = "invalid script URL"
+		return result
+	}
+	resp, body, err := fetchBody(ctx, sess, u, maxScriptSize)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.IsReachable = true
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 400 {
+		return result
+	}
+	result.Size = len(body)
+	analyzeScript(&result, sess.secrets, scriptURL, string(body), beautify)
+
+	if ref := sourceMapRef(resp.Header, body); ref != "" {
+		followSourceMap(ctx, sess, &result, resp.Request.URL, ref)
+	}
+	return result
+}
+
+// analyzeScript beautifies src and records its findings on result.
+func analyzeScript(result *JSAnalysisResult, secrets *secretScanner, file, src string, beautify bool) {
+	pretty := beautifyJS(src)
+	if beautify {
+		result.Beautified = pretty
+	}
+	extractJS(result, secrets, file, pretty)
+}
+
+var (
+	jsPathPattern    = regexp.MustCompile("[\"'`](/[A-Za-z0-9_\\-./{}:$%?=&]+)[\"'`]")
+	jsRelPathPattern = regexp.MustCompile("[\"'`]((?:api|rest|graphql|internal|admin|auth|oauth2?|v[0-9]+)/[A-Za-z0-9_\\-./{}:$%?=&]*)[\"'`]")
+	jsURLPattern     = regexp.MustCompile("https?://[^\\s\"'`<>\\\\]+")
+	jsGraphQLPattern = regexp.MustCompile(`\b(query|mutation|subscription)\s+([A-Za-z_][A-Za-z0-9_]*)\s*[({]`)
+	jsOperationName  = regexp.MustCompile(`operationName["']?\s*:\s*["']([A-Za-z_][A-Za-z0-9_]*)["']`)
+	jsSourceMapRef   = regexp.MustCompile(`(?m)^[ \t]*//[#@][ \t]*sourceMappingURL=(\S+)[ \t]*$`)
+)
+
+// jsBucketPatterns name cloud storage. The first submatch is the bucket.
+var jsBucketPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"s3", regexp.MustCompile(`(?i)\b([a-z0-9][a-z0-9.\-]{1,61}[a-z0-9])\.s3[.\-](?:[a-z0-9\-]+\.)?amazonaws\.com`)},
+	{"s3", regexp.MustCompile(`(?i)(?:^|[^a-z0-9.\-])s3[.\-](?:[a-z0-9\-]+\.)?amazonaws\.com/([a-z0-9][a-z0-9.\-]{1,61}[a-z0-9])`)},
+	{"s3", regexp.MustCompile(`(?i)\bs3://([a-z0-9][a-z0-9.\-]{1,61}[a-z0-9])`)},
+	{"gcs", regexp.MustCompile(`(?i)(?:^|[^a-z0-9.\-])storage\.googleapis\.com/([a-z0-9][a-z0-9._\-]{1,61}[a-z0-9])`)},
+	{"gcs", regexp.MustCompile(`(?i)\b([a-z0-9][a-z0-9._\-]{1,61}[a-z0-9])\.storage\.googleapis\.com`)},
+	{"gcs", regexp.MustCompile(`(?i)\bgs://([a-z0-9][a-z0-9._\-]{1,61}[a-z0-9])`)},
+	{"azure", regexp.MustCompile(`(?i)\b([a-z0-9]{3,24})\.blob\.core\.windows\.net`)},
+	{"spaces", regexp.MustCompile(`(?i)\b([a-z0-9][a-z0-9\-]{1,61}[a-z0-9])\.[a-z0-9]+\.digitaloceanspaces\.com`)},
+	{"firebase", regexp.MustCompile(`(?i)\b([a-z0-9][a-z0-9\-]{1,61}[a-z0-9])\.firebaseio\.com`)},
+	{"firebase", regexp.MustCompile(`(?i)\b([a-z0-9][a-z0-9\-]{1,61}[a-z0-9])\.firebasestorage\.app`)},
+}
+
+// jsNoiseHosts are spec and documentation hosts that appear in most bundles.
+var jsNoiseHosts = []string{"w3.org", "schema.org", "reactjs.org", "react.dev", "jquery.org", "mozilla.org", "ogp.me", "purl.org", "xmlns.com", "json-schema.org"}
+
+// extractJS records the paths, URLs, GraphQL operations, buckets and secrets
+// of code, a file of the script described by result.
+func extractJS(result *JSAnalysisResult, secrets *secretScanner, file, code string) {
+	for _, re := range []*regexp.Regexp{jsPathPattern, jsRelPathPattern} {
+		for _, m := range re.FindAllStringSubmatch(code, -1) {
+			if isAPIPath(m[1]) {
+				result.Paths = appendUnique(result.Paths, m[1])
+			}
+		}
+	}
+	for _, m := range jsURLPattern.FindAllString(code, -1) {
+		m = strings.TrimRight(m, ".,;:)]}")
+		if u, err := url.Parse(m); err == nil && u.Host != "" && !isNoiseHost(u.Hostname()) {
+			result.URLs = appendUnique(result.URLs, m)
+		}
+	}
+	for _, m := range jsGraphQLPattern.FindAllStringSubmatch(code, -1) {
+		result.GraphQL = appendUnique(result.GraphQL, m[1]+" "+m[2])
+	}
+	for _, m := range jsOperationName.FindAllStringSubmatch(code, -1) {
+		result.GraphQL = appendUnique(result.GraphQL, "operation "+m[1])
+	}
+	for _, p := range jsBucketPatterns {
+		for _, m := range p.re.FindAllStringSubmatch(code, -1) {
+			result.Buckets = appendUnique(result.Buckets, p.kind+":"+strings.ToLower(m[1]))
+		}
+	}
+
+	result.Secrets = append(result.Secrets, secrets.Scan(file, []byte(code))...)
+
+	sort.Strings(result.Paths)
+	sort.Strings(result.URLs)
+	sort.Strings(result.GraphQL)
+	sort.Strings(result.Buckets)
+}
+
+// isAPIPath filters the quoted strings starting with "/" down to paths that
+// look like routes rather than assets, comments or regular expressions.
+func isAPIPath(p string) bool {
+	if len(p) < 2 || strings.HasPrefix(p, "//") || !strings.ContainsAny(p, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		return false
+	}
+	switch strings.ToLower(path.Ext(strings.SplitN(p, "?", 2)[0])) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp", ".css", ".woff", ".woff2", ".ttf", ".eot", ".otf", ".mp4", ".mp3", ".webm":
+		return false
+	}
+	return true
+}
+
+func isNoiseHost(host string) bool {
+	for _, h := range jsNoiseHosts {
+		if inDomain(strings.ToLower(host), h) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceMapRef returns the source map location announced by the response
+// headers or by the last sourceMappingURL comment of the script.
+func sourceMapRef(header http.Header, body []byte) string {
+	if ref := header.Get("SourceMap"); ref != "" {
+		return ref
+	}
+	if ref := header.Get("X-SourceMap"); ref != "" {
+		return ref
+	}
+	matches := jsSourceMapRef.FindAllSubmatch(body, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return string(matches[len(matches)-1][1])
+}
+
+// followSourceMap loads the source map at ref, relative to the script, and
+// extracts findings from the original sources it embeds. Third-party
+// sources under node_modules are skipped.
+func followSourceMap(ctx context.Context, sess *scanSession, result *JSAnalysisResult, script *url.URL, ref string) {
+	var data []byte
+	if strings.HasPrefix(ref, "data:") {
+		meta, payload, ok := strings.Cut(ref, ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return
+		}
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(payload); err != nil {
+			return
+		}
+		result.SourceMap = "inline"
+	} else {
+		u, err := script.Parse(ref)
+		if err != nil {
+			return
+		}
+		resp, body, err := fetchBody(ctx, sess, u, maxSourceMapSize)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return
+		}
+		data = body
+		result.SourceMap = u.String()
+	}
+
+	var sm struct {
+		Sources        []string `json:"sources"`
+		SourcesContent []string `json:"sourcesContent"`
+	}
+	if err := json.Unmarshal(data, &sm); err != nil {
+		result.SourceMap = ""
+		return
+	}
+	for i, name := range sm.Sources {
+		if strings.Contains(name, "node_modules/") {
+			continue
+		}
+		result.SourceFiles = append(result.SourceFiles, name)
+		if i < len(sm.SourcesContent) && sm.SourcesContent[i] != "" {
+			extractJS(result, sess.secrets, name, sm.SourcesContent[i])
+		}
+	}
+}
+
+// analyzeCrawledScripts opens the scripts the crawler found under the
+// subdomain's registrable domain and feeds their findings back into result.
+func analyzeCrawledScripts(ctx context.Context, sess *scanSession, result *AnalysisResult, root *url.URL) {
+	domain := registrableDomain(root.Hostname())
+	known := make(map[string]bool)
+	for _, ep := range result.Endpoints {
+		known[ep.URL] = true
+	}
+	addEndpoint := func(u *url.URL, source string, depth int) {
+		if norm := normalizeURL(u); !known[norm] {
+			known[norm] = true
+			result.Endpoints = append(result.Endpoints, Endpoint{URL: norm, Method: "GET", Source: source, Depth: depth})
+		}
+	}
+	addTag := func(name, kind string) {
+		for _, t := range result.Tags {
+			if t.Name == name {
+				return
+			}
+		}
+		result.Tags = append(result.Tags, Tag{Name: name, Type: kind})
+	}
+
+	endpoints := result.Endpoints // appended to below
+	for _, ep := range endpoints {
+		if len(result.Scripts) >= sess.crawl.scripts || ctx.Err() != nil {
+			break
+		}
+		u, err := url.Parse(ep.URL)
+		if err != nil || !isScriptURL(u) || registrableDomain(u.Hostname()) != domain {
+			continue
+		}
+		js := analyzeScriptURL(ctx, sess, ep.URL, false)
+		js.Source = ep.Source
+		result.Scripts = append(result.Scripts, js)
+
+		for _, p := range js.Paths {
+			if u, err := root.Parse(p); err == nil {
+				addEndpoint(u, js.URL, ep.Depth+1)
+			}
+		}
+		for _, raw := range js.URLs {
+			if u, err := url.Parse(raw); err == nil && registrableDomain(u.Hostname()) == domain {
+				addEndpoint(u, js.URL, ep.Depth+1)
+			}
+		}
+		for _, op := range js.GraphQL {
+			addTag("GraphQL: "+op, "graphql")
+		}
+		for _, b := range js.Buckets {
+			addTag("Bucket: "+b, "bucket")
+		}
+		reportSecrets(result, js.Secrets)
+	}
+}
+
+func isScriptURL(u *url.URL) bool {
+	ext := strings.ToLower(path.Ext(u.Path))
+	return ext == ".js" || ext == ".mjs"
+}
+
+// fetchBody GETs u through the session and reads at most limit bytes.
+func fetchBody(ctx context.Context, sess *scanSession, u *url.URL, limit int64) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := sess.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	return resp, body, err
+}
+
+// lineOffsets returns the offset of every line start after the first. The
+// number of offsets up to a position, plus one, is its line number.
+func lineOffsets(s string) []int {
+	var offsets []int
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// redactSecret keeps enough of a secret to recognise it.
+func redactSecret(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	stars := len(s) - 4
+	if stars > 12 {
+		stars = 12
+	}
+	return s[:4] + strings.Repeat("*", stars)
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+// writeScriptReport summarises the JavaScript analysis of a subdomain.
+func writeScriptReport(b *strings.Builder, scripts []JSAnalysisResult) {
+	b.WriteString("\nJavaScript Analysis:\n")
+	for _, js := range scripts {
+		fmt.Fprintf(b, "- %s", js.URL)
+		if js.SourceMap != "" {
+			fmt.Fprintf(b, " (source map: %s, %d files)", js.SourceMap, len(js.SourceFiles))
+		}
+		b.WriteString("\n")
+		for _, s := range js.Secrets {
+			writeSecretMatch(b, "  Secret: ", s)
+		}
+		if len(js.GraphQL) > 0 {
+			fmt.Fprintf(b, "  GraphQL: %s\n", strings.Join(js.GraphQL, ", "))
+		}
+		if len(js.Buckets) > 0 {
+			fmt.Fprintf(b, "  Buckets: %s\n", strings.Join(js.Buckets, ", "))
+		}
+		if len(js.Paths) > 0 {
+			fmt.Fprintf(b, "  Paths: %d, URLs: %d\n", len(js.Paths), len(js.URLs))
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package modules
+
+import "strings"
+
+// beautifyJS re-indents JavaScript so that minified bundles become readable
+// and findings can be located by line. It only inserts or removes whitespace
+// outside string, template, regular expression and comment literals, so
+// every literal survives unchanged.
+func beautifyJS(src string) string {
+	var b strings.Builder
+	b.Grow(len(src) + len(src)/4)
+	indent, parens := 0, 0
+	lineStart := true
+	var prev byte // last significant code character written
+
+	newline := func() {
+		if !lineStart {
+			b.WriteByte('\n')
+			lineStart = true
+		}
+	}
+	write := func(s string) {
+		if lineStart {
+			b.WriteString(strings.Repeat("  ", indent))
+			lineStart = false
+		}
+		b.WriteString(s)
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\n':
+			newline()
+		case c == ' ' || c == '\t' || c == '\r':
+			if !lineStart && i+1 < len(src) && !isJSSpace(src[i+1]) {
+				write(" ")
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			write(src[i : i+end])
+			newline()
+			i += end
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				end = len(src) - i - 2
+			} else {
+				end += 2
+			}
+			write(src[i : i+2+end])
+			i += 1 + end
+		case c == '"' || c == '\'' || c == '`':
+			end := skipJSString(src, i)
+			write(src[i:end])
+			i = end - 1
+			prev = c
+		case c == '/' && regexAllowedAfter(prev, b.String()):
+			end := skipJSRegex(src, i)
+			write(src[i:end])
+			i = end - 1
+			prev = 'a' // a regex is an operand, like an identifier
+		case c == '{' && strings.HasPrefix(src[nextNonSpace(src, i+1):], "}"):
+			write("{}")
+			i = nextNonSpace(src, i+1)
+			if j := nextNonSpace(src, i+1); j >= len(src) || !strings.ContainsRune(";,).](", rune(src[j])) {
+				newline()
+			}
+			prev = '}'
+		case c == '{':
+			write("{")
+			indent++
+			newline()
+			prev = c
+		case c == '}':
+			if indent > 0 {
+				indent--
+			}
+			newline()
+			write("}")
+			// Keep "};", "}," "})" and the like together, break otherwise.
+			if j := nextNonSpace(src, i+1); j >= len(src) || !strings.ContainsRune(";,).](", rune(src[j])) {
+				newline()
+			}
+			prev = c
+		case c == ';':
+			write(";")
+			if parens == 0 {
+				newline()
+			}
+			prev = c
+		default:
+			switch c {
+			case '(', '[':
+				parens++
+			case ')', ']':
+				if parens > 0 {
+					parens--
+				}
+			}
+			write(string(c))
+			prev = c
+		}
+	}
+	return b.String()
+}
+
+func isJSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func nextNonSpace(src string, i int) int {
+	for i < len(src) && isJSSpace(src[i]) {
+		i++
+	}
+	return i
+}
+
+// skipJSString returns the index just past the literal starting at src[i].
+// Quoted strings end at an unescaped newline so that a mistake cannot swallow
+// the rest of the file; template literals may span lines.
+func skipJSString(src string, i int) int {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		case '\n':
+			if quote != '`' {
+				return j
+			}
+		}
+	}
+	return len(src)
+}
+
+// skipJSRegex returns the index just past the regular expression literal,
+// including its flags, starting at src[i].
+func skipJSRegex(src string, i int) int {
+	inClass := false
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '\n':
+			return j
+		case '/':
+			if !inClass {
+				j++
+				for j < len(src) && (src[j] >= 'a' && src[j] <= 'z') {
+					j++
+				}
+				return j
+			}
+		}
+	}
+	return len(src)
+}
+
+// regexAllowedAfter tells a regular expression from a division by the code
+// written before it.
+func regexAllowedAfter(prev byte, written string) bool {
+	if prev == 0 {
+		return true
+	}
+	if strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", rune(prev)) {
+		return true
+	}
+	trimmed := strings.TrimRight(written, " \n")
+	for _, kw := range []string{"return", "typeof", "case", "do", "else", "in", "of", "void", "yield", "delete", "throw"} {
+		if strings.HasSuffix(trimmed, kw) {
+			before := len(trimmed) - len(kw) - 1
+			if before < 0 || !isJSIdentChar(trimmed[before]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isJSIdentChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,435 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Notification events.
+const (
+	NotifyJobCompleted  = "job_completed"
+	NotifyJobFailed     = "job_failed" // timed out or interrupted; cancelled jobs are not reported
+	NotifyFindings      = "findings"   // sent when a job ends with findings at or above the sink's threshold
+	NotifyScheduleAlert = "schedule_alert"
+)
+
+var notifyEvents = []string{NotifyJobCompleted, NotifyJobFailed, NotifyFindings, NotifyScheduleAlert}
+
+// Notifier sink types. Requests may only use the webhook and email types;
+// the others write on the server and are only configurable globally.
+const (
+	SinkWebhook = "webhook" // the Notification as JSON
+	SinkSlack   = "slack"   // Slack incoming webhook
+	SinkDiscord = "discord" // Discord webhook
+	SinkEmail   = "email"   // sent through the configured SMTP server
+	SinkFile    = "file"    // JSON lines appended to a file
+	SinkSyslog  = "syslog"
+)
+
+var (
+	sinkTypes    = []string{SinkWebhook, SinkSlack, SinkDiscord, SinkEmail, SinkFile, SinkSyslog}
+	jobSinkTypes = []string{SinkWebhook, SinkSlack, SinkDiscord, SinkEmail}
+)
+
+// NotifierConfig is one sink, from the config file or the notify field of
+// a request or schedule.
+type NotifierConfig struct {
+	Type        string   `yaml:"type" json:"type"`
+	URL         string   `yaml:"url" json:"url,omitempty"`                 // webhook, slack, discord
+	To          []string `yaml:"to" json:"to,omitempty"`                   // email recipients
+	Path        string   `yaml:"path" json:"-"`                            // file
+	Tag         string   `yaml:"tag" json:"-"`                             // syslog, default vuln-ai
+	Events      []string `yaml:"events" json:"events,omitempty"`           // default all
+	MinSeverity string   `yaml:"minSeverity" json:"minSeverity,omitempty"` // default notify.minSeverity
+}
+
+// Notification is what sinks receive.
+type Notification struct {
+	Event    string         `json:"event"`
+	JobID    string         `json:"jobId"`
+	Kind     string         `json:"kind,omitempty"`
+	Status   string         `json:"status,omitempty"`
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Findings []Finding      `json:"findings,omitempty"`
+	Alert    *ScheduleAlert `json:"alert,omitempty"`
+	Time     time.Time      `json:"time"`
+}
+
+// Notifier delivers notifications to one destination.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// webhookAttempts and webhookBackoff bound the retries of webhook sinks; the
+// delay doubles after each failed attempt.
+var (
+	webhookAttempts = 4
+	webhookBackoff  = time.Second
+)
+
+// maxNotifyFindings bounds the findings listed in a message.
+const maxNotifyFindings = 20
+
+func (n NotifierConfig) validate(global bool) error {
+	allowed := jobSinkTypes
+	if global {
+		allowed = sinkTypes
+	}
+	if !containsString(allowed, n.Type) {
+		return fmt.Errorf("unknown notifier type %q, expected one of %s", n.Type, strings.Join(allowed, ", "))
+	}
+	switch n.Type {
+	case SinkWebhook, SinkSlack, SinkDiscord:
+		if u, err := url.Parse(n.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s notifier: invalid URL %q", n.Type, n.URL)
+		}
+	case SinkEmail:
+		if len(n.To) == 0 {
+			return errors.New("email notifier: no recipients")
+		}
+		if cfg.Notify.SMTP.Host == "" && !global {
+			return errors.New("email notifier: no SMTP server configured")
+		}
+		for _, to := range n.To {
+			addr, err := parseEmailAddress(to)
+			if err != nil {
+				return fmt.Errorf("email notifier: %v", err)
+			}
+			// Requests must not turn the server's SMTP account into a relay.
+			if !global && !recipientAllowed(addr.Address) {
+				return fmt.Errorf("email notifier: recipient %q is not in notify.smtp.allowedRecipients", addr.Address)
+			}
+		}
+	case SinkFile:
+		if n.Path == "" {
+			return errors.New("file notifier: no path")
+		}
+	}
+	for _, e := range n.Events {
+		if !containsString(notifyEvents, e) {
+			return fmt.Errorf("%s notifier: unknown event %q, expected one of %s", n.Type, e, strings.Join(notifyEvents, ", "))
+		}
+	}
+	if n.MinSeverity != "" && severityRank(n.MinSeverity) < 0 {
+		return fmt.Errorf("%s notifier: unknown severity %q", n.Type, n.MinSeverity)
+	}
+	return nil
+}
+
+// parseJobSinks reads the notify field of a request, a JSON list of sinks.
+func parseJobSinks(value string) ([]NotifierConfig, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var sinks []NotifierConfig
+	if err := json.Unmarshal([]byte(value), &sinks); err != nil {
+		return nil, fmt.Errorf("invalid notify field: %v", err)
+	}
+	for _, s := range sinks {
+		if err := s.validate(false); err != nil {
+			return nil, err
+		}
+	}
+	return sinks, nil
+}
+
+// parseEmailAddress parses a single address such as "a@example.com" or
+// "Security <a@example.com>". Line breaks, which would let the address
+// inject headers, are rejected.
+func parseEmailAddress(s string) (*mail.Address, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, fmt.Errorf("invalid email address %q", s)
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address %q", s)
+	}
+	return addr, nil
+}
+
+// recipientAllowed reports whether addr matches notify.smtp.allowedRecipients.
+func recipientAllowed(addr string) bool {
+	addr = strings.ToLower(addr)
+	for _, allowed := range cfg.Notify.SMTP.AllowedRecipients {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if addr == allowed || (strings.HasPrefix(allowed, "@") && strings.HasSuffix(addr, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n NotifierConfig) wants(event string) bool {
+	return len(n.Events) == 0 || containsString(n.Events, event)
+}
+
+func (n NotifierConfig) minSeverity() string {
+	switch {
+	case n.MinSeverity != "":
+		return n.MinSeverity
+	case cfg.Notify.MinSeverity != "":
+		return cfg.Notify.MinSeverity
+	}
+	return SeverityHigh
+}
+
+// notifier builds the Notifier of a sink.
+func (n NotifierConfig) notifier() Notifier {
+	switch n.Type {
+	case SinkEmail:
+		return emailNotifier{smtp: cfg.Notify.SMTP, to: n.To}
+	case SinkFile:
+		return fileNotifier{path: n.Path}
+	case SinkSyslog:
+		return newSyslogNotifier(n.Tag)
+	}
+	return webhookNotifier{url: n.URL, format: n.Type, client: &http.Client{Timeout: cfg.Scan.HTTPTimeout}}
+}
+
+var pendingNotifications sync.WaitGroup
+
+// WaitNotifications waits up to timeout for notifications being delivered,
+// so that they survive a shutdown.
+func WaitNotifications(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		pendingNotifications.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Gave up waiting for notifications after %s", timeout)
+	}
+}
+
+// dispatch sends n to every sink that subscribes to its event, in the
+// background. For the findings event each sink only gets the findings at or
+// above its threshold, and nothing if there are none.
+func dispatch(sinks []NotifierConfig, n Notification) {
+	for _, sink := range sinks {
+		if !sink.wants(n.Event) {
+			continue
+		}
+		msg := n
+		if n.Event == NotifyFindings {
+			msg.Findings = nil
+			min := severityRank(sink.minSeverity())
+			for _, f := range n.Findings {
+				if severityRank(f.Severity) >= min {
+					msg.Findings = append(msg.Findings, f)
+				}
+			}
+			if len(msg.Findings) == 0 {
+				continue
+			}
+			msg.Title = fmt.Sprintf("%d findings of %s severity or higher in job %s", len(msg.Findings), sink.minSeverity(), n.JobID)
+			msg.Message = findingsMessage(msg.Findings)
+		}
+		pendingNotifications.Add(1)
+		go func(sink NotifierConfig, msg Notification) {
+			defer pendingNotifications.Done()
+			if err := sink.notifier().Notify(context.Background(), msg); err != nil {
+				log.Printf("Error sending %s notification for job %s to %s sink: %v", msg.Event, msg.JobID, sink.Type, err)
+			}
+		}(sink, msg)
+	}
+}
+
+// notifyJobEnd sends the completion or failure of a job and its findings to
+// the global sinks and those of the job.
+func notifyJobEnd(jobID, kind, status string, jobSinks []NotifierConfig, findings []Finding) {
+	sinks := append(append([]NotifierConfig{}, cfg.Notify.Sinks...), jobSinks...)
+	if len(sinks) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	base := Notification{JobID: jobID, Kind: kind, Status: status, Time: now}
+	switch status {
+	case JobStatusCompleted:
+		n := base
+		n.Event = NotifyJobCompleted
+		n.Title = fmt.Sprintf("%s job %s completed", kind, jobID)
+		n.Message = fmt.Sprintf("%d findings.", len(findings))
+		dispatch(sinks, n)
+	case JobStatusTimedOut, JobStatusInterrupted:
+		n := base
+		n.Event = NotifyJobFailed
+		n.Title = fmt.Sprintf("%s job %s %s", kind, jobID, strings.ReplaceAll(status, "_", " "))
+		n.Message = fmt.Sprintf("The job ended early with %d findings in its partial results.", len(findings))
+		dispatch(sinks, n)
+	}
+	if len(findings) > 0 {
+		n := base
+		n.Event = NotifyFindings
+		n.Findings = findings
+		dispatch(sinks, n)
+	}
+}
+
+func findingsMessage(findings []Finding) string {
+	var b strings.Builder
+	for i, f := range findings {
+		if i == maxNotifyFindings {
+			fmt.Fprintf(&b, "... and %d more\n", len(findings)-i)
+			break
+		}
+		fmt.Fprintf(&b, "[%s] %s - %s\n", f.Severity, f.Title, f.Location)
+	}
+	return b.String()
+}
+
+// webhookNotifier posts to a URL, as the Notification JSON or in the
+// message format of Slack or Discord. Network errors, 429 and 5xx responses
+// are retried with exponential backoff.
+type webhookNotifier struct {
+	url    string
+	format string
+	client *http.Client
+}
+
+func (w webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	text := n.Title
+	if n.Message != "" {
+		text += "\n" + n.Message
+	}
+	var payload interface{} = n
+	switch w.format {
+	case SinkSlack:
+		payload = map[string]string{"text": text}
+	case SinkDiscord:
+		if utf8.RuneCountInString(text) > 2000 { // Discord's message limit, in characters
+			text = string([]rune(text)[:1997]) + "..."
+		}
+		payload = map[string]string{"content": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, body)
+		var permanent permanentError
+		if err == nil || errors.As(err, &permanent) || attempt == webhookAttempts {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// permanentError is a webhook response that retrying will not change.
+type permanentError struct{ status string }
+
+func (e permanentError) Error() string { return "webhook returned " + e.status }
+
+func (w webhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{status: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return permanentError{status: resp.Status}
+}
+
+type emailNotifier struct {
+	smtp SMTPConfig
+	to   []string
+}
+
+func (e emailNotifier) Notify(ctx context.Context, n Notification) error {
+	port := e.smtp.Port
+	if port == 0 {
+		port = 587
+	}
+	// The addresses were validated with the sink; check again before they
+	// go into headers.
+	from, err := parseEmailAddress(e.smtp.From)
+	if err != nil {
+		return err
+	}
+	var to, toHeader []string
+	for _, s := range e.to {
+		addr, err := parseEmailAddress(s)
+		if err != nil {
+			return err
+		}
+		to = append(to, addr.Address)
+		toHeader = append(toHeader, addr.String())
+	}
+	// Titles can contain user input, such as schedule names.
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(n.Title)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(toHeader, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[VULN_AI] "+subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Message, "\n", "\r\n"))
+	fmt.Fprintf(&msg, "\r\nJob: %s\r\n", n.JobID)
+
+	var auth smtp.Auth
+	if e.smtp.Username != "" {
+		auth = smtp.PlainAuth("", e.smtp.Username, e.smtp.Password, e.smtp.Host)
+	}
+	return smtp.SendMail(e.smtp.Host+":"+strconv.Itoa(port), auth, from.Address, to, msg.Bytes())
+}
+
+var fileNotifierMu sync.Mutex
+
+// fileNotifier appends each notification to a file as a JSON line.
+type fileNotifier struct {
+	path string
+}
+
+func (f fileNotifier) Notify(ctx context.Context, n Notification) error {
+	line, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	fileNotifierMu.Lock()
+	defer fileNotifierMu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !plan9 && !js && !wasip1
+
+package modules
+
+import (
+	"context"
+	"log/syslog"
+)
+
+type syslogNotifier struct {
+	tag string
+}
+
+func newSyslogNotifier(tag string) Notifier {
+	if tag == "" {
+		tag = "vuln-ai"
+	}
+	return syslogNotifier{tag: tag}
+}
+
+// Notify writes n to the local syslog daemon, failures and findings as
+// warnings.
+func (s syslogNotifier) Notify(ctx context.Context, n Notification) error {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_NOTICE, s.tag)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	msg := n.Title + ": " + n.Message
+	if n.Event == NotifyJobFailed || n.Event == NotifyFindings || n.Event == NotifyScheduleAlert {
+		return w.Warning(msg)
+	}
+	return w.Notice(msg)
+}
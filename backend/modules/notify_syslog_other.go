@@ -0,0 +1,18 @@
+//go:build windows || plan9 || js || wasip1
+
+package modules
+
+import (
+	"context"
+	"errors"
+)
+
+type syslogNotifier struct{}
+
+func newSyslogNotifier(tag string) Notifier {
+	return syslogNotifier{}
+}
+
+func (syslogNotifier) Notify(ctx context.Context, n Notification) error {
+	return errors.New("syslog is not available on this platform")
+}
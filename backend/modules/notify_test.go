@@ -0,0 +1,165 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// webhookServer answers the i-th request (from 0) with statuses[i], or 200
+// past the end, and records the bodies it received.
+type webhookServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	t.Helper()
+	w := &webhookServer{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.mu.Lock()
+		n := len(w.bodies)
+		w.bodies = append(w.bodies, body)
+		w.mu.Unlock()
+		if n < len(statuses) {
+			rw.WriteHeader(statuses[n])
+		}
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhookServer) requests() []map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]map[string]interface{}(nil), w.bodies...)
+}
+
+func fastWebhookRetries(t *testing.T) {
+	attempts, backoff := webhookAttempts, webhookBackoff
+	webhookAttempts, webhookBackoff = 4, time.Millisecond
+	t.Cleanup(func() { webhookAttempts, webhookBackoff = attempts, backoff })
+}
+
+func TestWebhookRetries(t *testing.T) {
+	fastWebhookRetries(t)
+	srv := newWebhookServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+	n := Notification{Event: NotifyJobCompleted, JobID: "job-1", Title: "done"}
+	err := webhookNotifier{url: srv.URL, format: SinkWebhook, client: srv.Client()}.Notify(context.Background(), n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.requests()
+	if len(reqs) != 3 {
+		t.Fatalf("%d requests, want 3", len(reqs))
+	}
+	if reqs[2]["event"] != NotifyJobCompleted || reqs[2]["jobId"] != "job-1" {
+		t.Errorf("payload = %v", reqs[2])
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	fastWebhookRetries(t)
+	srv := newWebhookServer(t, 500, 500, 500, 500, 500)
+	if err := (webhookNotifier{url: srv.URL, client: srv.Client()}).Notify(context.Background(), Notification{}); err == nil {
+		t.Error("expected an error after the last attempt")
+	}
+	if n := len(srv.requests()); n != webhookAttempts {
+		t.Errorf("%d requests, want %d", n, webhookAttempts)
+	}
+}
+
+func TestWebhookPermanentError(t *testing.T) {
+	fastWebhookRetries(t)
+	srv := newWebhookServer(t, http.StatusBadRequest)
+	if err := (webhookNotifier{url: srv.URL, client: srv.Client()}).Notify(context.Background(), Notification{}); err == nil {
+		t.Error("expected an error for a 400 response")
+	}
+	if n := len(srv.requests()); n != 1 {
+		t.Errorf("%d requests, want 1: 4xx responses are not retried", n)
+	}
+}
+
+func TestChatPayloads(t *testing.T) {
+	srv := newWebhookServer(t)
+	n := Notification{Title: "title", Message: strings.Repeat("é", 3000)}
+
+	if err := (webhookNotifier{url: srv.URL, format: SinkDiscord, client: srv.Client()}).Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if err := (webhookNotifier{url: srv.URL, format: SinkSlack, client: srv.Client()}).Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.requests()
+	content, _ := reqs[0]["content"].(string)
+	if !utf8.ValidString(content) || utf8.RuneCountInString(content) != 2000 || !strings.HasSuffix(content, "...") {
+		t.Errorf("discord content: valid %v, %d characters", utf8.ValidString(content), utf8.RuneCountInString(content))
+	}
+	if text, _ := reqs[1]["text"].(string); !strings.HasPrefix(text, "title\n") {
+		t.Errorf("slack text = %.20q", text)
+	}
+}
+
+func TestDispatchFiltersFindings(t *testing.T) {
+	srv := newWebhookServer(t)
+	sinks := []NotifierConfig{
+		{Type: SinkWebhook, URL: srv.URL, Events: []string{NotifyFindings}, MinSeverity: SeverityHigh},
+		{Type: SinkWebhook, URL: srv.URL, Events: []string{NotifyFindings}, MinSeverity: SeverityCritical},
+	}
+	dispatch(sinks, Notification{Event: NotifyFindings, JobID: "job-1", Findings: []Finding{
+		{Title: "a", Severity: SeverityHigh},
+		{Title: "b", Severity: SeverityLow},
+	}})
+	WaitNotifications(5 * time.Second)
+
+	reqs := srv.requests()
+	if len(reqs) != 1 {
+		t.Fatalf("%d requests, want 1: the critical sink has nothing to report", len(reqs))
+	}
+	if findings, _ := reqs[0]["findings"].([]interface{}); len(findings) != 1 {
+		t.Errorf("findings = %v", reqs[0]["findings"])
+	}
+}
+
+func TestJobEmailSinks(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg = DefaultConfig()
+	cfg.Notify.SMTP.Host = "smtp.example.com"
+	cfg.Notify.SMTP.AllowedRecipients = []string{"oncall@example.com", "@security.example.com"}
+
+	tests := []struct {
+		to []string
+		ok bool
+	}{
+		{[]string{"oncall@example.com"}, true},
+		{[]string{"Team <alice@security.example.com>"}, true},
+		{[]string{"someone@elsewhere.org"}, false},
+		{[]string{"oncall@example.com\r\nBcc: someone@elsewhere.org"}, false},
+		{[]string{"not an address"}, false},
+	}
+	for _, tt := range tests {
+		sinks, _ := json.Marshal([]NotifierConfig{{Type: SinkEmail, To: tt.to}})
+		if _, err := parseJobSinks(string(sinks)); (err == nil) != tt.ok {
+			t.Errorf("to %q: err = %v, want ok %v", tt.to, err, tt.ok)
+		}
+	}
+
+	// The config file's own sinks may send anywhere, but not inject headers.
+	if err := (NotifierConfig{Type: SinkEmail, To: []string{"someone@elsewhere.org"}}).validate(true); err != nil {
+		t.Error(err)
+	}
+	if err := (NotifierConfig{Type: SinkEmail, To: []string{"a@example.com\nBcc: b@example.com"}}).validate(true); err == nil {
+		t.Error("address with a line break accepted")
+	}
+}
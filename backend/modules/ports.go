@@ -0,0 +1,428 @@
+package modules
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PortResult is an open port and the service found behind it.
+type PortResult struct {
+	Port     int    `json:"Port"`
+	Protocol string `json:"Protocol"` // tcp
+	Service  string `json:"Service"`  // e.g. ssh, http, mysql; guessed from the port number if the service did not identify itself
+	Product  string `json:"Product"`  // e.g. OpenSSH, nginx
+	Version  string `json:"Version"`
+	TLS      bool   `json:"TLS"`
+	Banner   string `json:"Banner"` // start of the service's first reply, non-printable bytes replaced
+}
+
+// portProfiles are the named port sets a scan can select. The top-N sets are
+// the most frequently open TCP ports according to nmap's statistics.
+var portProfiles = map[string]string{
+	"top-100":   "7,9,13,21-23,25-26,37,53,79-81,88,106,110-111,113,119,135,139,143-144,179,199,389,427,443-445,465,513-515,543-544,548,554,587,631,646,873,990,993,995,1025-1029,1110,1433,1720,1723,1755,1900,2000-2001,2049,2121,2717,3000,3128,3306,3389,3986,4899,5000,5009,5051,5060,5101,5190,5357,5432,5631,5666,5800,5900,6000-6001,6646,7070,8000,8008-8009,8080-8081,8443,8888,9100,9999-10000,32768,49152-49157",
+	"top-1000":  "1,3-4,6-7,9,13,17,19-26,30,32-33,37,42-43,49,53,70,79-85,88-90,99-100,106,109-111,113,119,125,135,139,143-144,146,161,163,179,199,211-212,222,254-256,259,264,280,301,306,311,340,366,389,406-407,416-417,425,427,443-445,458,464-465,481,497,500,512-515,524,541,543-545,548,554-555,563,587,593,616-617,625,631,636,646,648,666-668,683,687,691,700,705,711,714,720,722,726,749,765,777,783,787,800-801,808,843,873,880,888,898,900-903,911-912,981,987,990,992-993,995,999-1002,1007,1009-1011,1021-1100,1102,1104-1108,1110-1114,1117,1119,1121-1124,1126,1130-1132,1137-1138,1141,1145,1147-1149,1151-1152,1154,1163-1166,1169,1174-1175,1183,1185-1187,1192,1198-1199,1201,1213,1216-1218,1233-1234,1236,1244,1247-1248,1259,1271-1272,1277,1287,1296,1300-1301,1309-1311,1322,1328,1334,1352,1417,1433-1434,1443,1455,1461,1494,1500-1501,1503,1521,1524,1533,1556,1580,1583,1594,1600,1641,1658,1666,1687-1688,1700,1717-1721,1723,1755,1761,1782-1783,1801,1805,1812,1839-1840,1862-1864,1875,1900,1914,1935,1947,1971-1972,1974,1984,1998-2010,2013,2020-2022,2030,2033-2035,2038,2040-2043,2045-2049,2065,2068,2099-2100,2103,2105-2107,2111,2119,2121,2126,2135,2144,2160-2161,2170,2179,2190-2191,2196,2200,2222,2251,2260,2288,2301,2323,2366,2381-2383,2393-2394,2399,2401,2492,2500,2522,2525,2557,2601-2602,2604-2605,2607-2608,2638,2701-2702,2710,2717-2718,2725,2800,2809,2811,2869,2875,2909-2910,2920,2967-2968,2998,3000-3001,3003,3005-3007,3011,3013,3017,3030-3031,3052,3071,3077,3128,3168,3211,3221,3260-3261,3268-3269,3283,3300-3301,3306,3322-3325,3333,3351,3367,3369-3372,3389-3390,3404,3476,3493,3517,3527,3546,3551,3580,3659,3689-3690,3703,3737,3766,3784,3800-3801,3809,3814,3826-3828,3851,3869,3871,3878,3880,3889,3905,3914,3918,3920,3945,3971,3986,3995,3998,4000-4006,4045,4111,4125-4126,4129,4224,4242,4279,4321,4343,4443-4446,4449,4550,4567,4662,4848,4899-4900,4998,5000-5004,5009,5030,5033,5050-5051,5054,5060-5061,5080,5087,5100-5102,5120,5190,5200,5214,5221-5222,5225-5226,5269,5280,5298,5357,5405,5414,5431-5432,5440,5500,5510,5544,5550,5555,5560,5566,5631,5633,5666,5678-5679,5718,5730,5800-5802,5810-5811,5815,5822,5825,5850,5859,5862,5877,5900-5904,5906-5907,5910-5911,5915,5922,5925,5950,5952,5959-5963,5987-5989,5998-6007,6009,6025,6059,6100-6101,6106,6112,6123,6129,6156,6346,6389,6502,6510,6543,6547,6565-6567,6580,6646,6666-6669,6689,6692,6699,6779,6788-6789,6792,6839,6881,6901,6969,7000-7002,7004,7007,7019,7025,7070,7100,7103,7106,7200-7201,7402,7435,7443,7496,7512,7625,7627,7676,7741,7777-7778,7800,7911,7920-7921,7937-7938,7999-8002,8007-8011,8021-8022,8031,8042,8045,8080-8090,8093,8099-8100,8180-8181,8192-8194,8200,8222,8254,8290-8292,8300,8333,8383,8400,8402,8443,8500,8600,8649,8651-8652,8654,8701,8800,8873,8888,8899,8994,9000-9003,9009-9011,9040,9050,9071,9080-9081,9090-9091,9099-9103,9110-9111,9200,9207,9220,9290,9415,9418,9485,9500,9502-9503,9535,9575,9593-9595,9618,9666,9876-9878,9898,9900,9917,9929,9943-9944,9968,9998-10004,10009-10010,10012,10024-10025,10082,10180,10215,10243,10566,10616-10617,10621,10626,10628-10629,10778,11110-11111,11967,12000,12174,12265,12345,13456,13722,13782-13783,14000,14238,14441-14442,15000,15002-15004,15660,15742,16000-16001,16012,16016,16018,16080,16113,16992-16993,17877,17988,18040,18101,18988,19101,19283,19315,19350,19780,19801,19842,20000,20005,20031,20221-20222,20828,21571,22939,23502,24444,24800,25734-25735,26214,27000,27352-27353,27355-27356,27715,28201,30000,30718,30951,31038,31337,32768-32785,33354,33899,34571-34573,35500,38292,40193,40911,41511,42510,44176,44442-44443,44501,45100,48080,49152-49161,49163,49165,49167,49175-49176,49400,49999-50003,50006,50300,50389,50500,50636,50800,51103,51493,52673,52822,52848,52869,54045,54328,55055-55056,55555,55600,56737-56738,57294,57797,58080,60020,60443,61532,61900,62078,63331,64623,64680,65000,65129,65389",
+	"web":       "80-81,300,443,591,593,832,981,1010,1311,2082-2083,2087,2095-2096,2480,3000,3128,3333,4243,4443,4567,4711-4712,4993,5000,5104,5108,5800,6543,7000,7001,7396,7474,8000-8001,8008,8014,8042,8069,8080-8083,8088,8090-8091,8118,8123,8172,8222,8243,8280-8281,8333,8443,8500,8834,8880,8888,8983,9000,9043,9060,9080,9090-9091,9200,9443,9800,9981,12443,16080,18091-18092,20720,28017",
+	"databases": "1433-1434,1521,1583,2483-2484,3050,3306,3351,5000,5432-5433,5984,6379,7000-7001,7199,7473-7474,7687,8086,8087,8098,8529,9042,9160,9200,9300,11211,26257,27017-27019,28015,28017,29015,33060,50000",
+	"full":      "1-65535",
+}
+
+// parsePorts expands a port specification: a comma-separated list of profile
+// names, ports and ranges such as "web,1-1024,8000-9000". The ports are
+// returned sorted and without duplicates.
+func parsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+	add := func(from, to int) {
+		for p := from; p <= to; p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	var expand func(spec string, inProfile bool) error
+	expand = func(spec string, inProfile bool) error {
+		for _, part := range SplitList(spec) {
+			if profile, ok := portProfiles[strings.ToLower(part)]; ok && !inProfile {
+				if err := expand(profile, true); err != nil {
+					return err
+				}
+				continue
+			}
+			lo, hi, isRange := strings.Cut(part, "-")
+			from, err := strconv.Atoi(strings.TrimSpace(lo))
+			to := from
+			if err == nil && isRange {
+				to, err = strconv.Atoi(strings.TrimSpace(hi))
+			}
+			if err != nil {
+				return fmt.Errorf("invalid port %q, expected a number, a range such as 8000-9000 or one of %s", part, strings.Join(portProfileNames(), ", "))
+			}
+			if from < 1 || to > 65535 || from > to {
+				return fmt.Errorf("invalid port range %q, ports are 1-65535", part)
+			}
+			add(from, to)
+		}
+		return nil
+	}
+	if err := expand(spec, false); err != nil {
+		return nil, err
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports in %q", spec)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+func portProfileNames() []string {
+	var names []string
+	for name := range portProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mustParsePorts is parsePorts for the built-in defaults.
+func mustParsePorts(spec string) []int {
+	ports, err := parsePorts(spec)
+	if err != nil {
+		panic(err)
+	}
+	return ports
+}
+
+// bannerTimeout is how long a service is given to greet or reply to a probe.
+const bannerTimeout = 3 * time.Second
+
+const maxBannerSize = 4096
+
+// wellKnownPorts name the service usually found on a port.
+var wellKnownPorts = map[int]string{
+	21: "ftp", 22: "ssh", 23: "telnet", 25: "smtp", 53: "domain", 80: "http", 110: "pop3", 111: "rpcbind",
+	135: "msrpc", 139: "netbios-ssn", 143: "imap", 443: "https", 445: "microsoft-ds", 465: "smtps", 587: "submission",
+	993: "imaps", 995: "pop3s", 1433: "mssql", 1521: "oracle", 1723: "pptp", 2375: "docker", 3306: "mysql",
+	3389: "rdp", 5432: "postgresql", 5900: "vnc", 5984: "couchdb", 6379: "redis", 8000: "http", 8080: "http-proxy",
+	8443: "https-alt", 9200: "elasticsearch", 11211: "memcached", 27017: "mongodb",
+}
+
+// tlsPorts expect a TLS handshake before anything else.
+var tlsPorts = map[int]bool{443: true, 465: true, 636: true, 990: true, 993: true, 995: true, 8443: true}
+
+// clientFirstProbes are sent right away to services known to wait for the
+// client. Other ports are first given bannerTimeout to greet, then sent an
+// HTTP request.
+var clientFirstProbes = map[string][]byte{
+	"http":          httpProbe,
+	"http-proxy":    httpProbe,
+	"docker":        httpProbe,
+	"couchdb":       httpProbe,
+	"elasticsearch": httpProbe,
+	"redis":         []byte("INFO server\r\n"),
+	"memcached":     []byte("version\r\n"),
+	"postgresql":    {0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}, // SSLRequest
+}
+
+var httpProbe = []byte("GET / HTTP/1.0\r\nUser-Agent: Mozilla/5.0\r\nAccept: */*\r\n\r\n")
+
+var (
+	sshBanner     = regexp.MustCompile(`^SSH-[\d.]+-(\S+)`)
+	ftpSMTPBanner = regexp.MustCompile(`^220[ -]`)
+	vncBanner     = regexp.MustCompile(`^RFB (\d{3}\.\d{3})`)
+	redisVersion  = regexp.MustCompile(`redis_version:(\S+)`)
+	// plainHTTPOnTLSPort matches the errors web servers send for HTTP on a
+	// TLS port.
+	plainHTTPOnTLSPort = regexp.MustCompile(`(?i)^HTTP/1\.[01] 400[\s\S]*(HTTP request to an HTTPS server|plain HTTP request was sent to HTTPS port|speaking plain HTTP to an SSL-enabled server)`)
+	productVersion     = regexp.MustCompile(`(?i)\b(vsFTPd|ProFTPD|Pure-FTPd|FileZilla Server|Postfix|Exim|Sendmail|Microsoft ESMTP|Dovecot|Courier|OpenSMTPD)[ /(]*v?(\d+(?:\.\d+)+[a-z0-9]*)?`)
+)
+
+// maxPortConcurrency caps the ports a job may hold open at once per host.
+const maxPortConcurrency = 500
+
+// scanPorts connects to the ports of the job on subdomain and identifies the
+// service on each open one. At most sess.portWorkers ports of one IP are
+// dialled and probed at once, however many of the job's names point at it.
+func scanPorts(ctx context.Context, sess *scanSession, subdomain string) []PortResult {
+	host := hostOnly(subdomain)
+	workers := sess.portWorkers
+	if workers > len(sess.ports) {
+		workers = len(sess.ports)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	var open []PortResult
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				release, err := sess.acquirePortSlot(ctx, host)
+				if err != nil {
+					continue
+				}
+				if conn, err := sess.dial(ctx, "tcp", host, p); err == nil {
+					res := probeService(ctx, sess, host, p, conn)
+					mu.Lock()
+					open = append(open, res)
+					mu.Unlock()
+				}
+				release()
+			}
+		}()
+	}
+feed:
+	for _, p := range sess.ports {
+		select {
+		case queue <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	sort.Slice(open, func(i, j int) bool { return open[i].Port < open[j].Port })
+	return open
+}
+
+// probeService grabs the banner of an open port over conn, the connection
+// that found it open, sending a probe if the service does not speak first.
+// A new connection is only made to try TLS after the clear text failed, or
+// the clear text after TLS failed on a TLS port. conn is closed.
+func probeService(ctx context.Context, sess *scanSession, host string, port int, conn net.Conn) PortResult {
+	res := PortResult{Port: port, Protocol: "tcp", Service: wellKnownPorts[port]}
+	if tlsPorts[port] {
+		if probeTLSService(ctx, conn, host, &res) {
+			return res
+		}
+		var err error
+		if conn, err = sess.dial(ctx, "tcp", host, port); err != nil {
+			return res
+		}
+	}
+
+	reply := exchangeBanner(conn, clientFirstProbes[res.Service])
+	conn.Close()
+	if (len(reply) == 0 || plainHTTPOnTLSPort.Match(reply)) && !tlsPorts[port] {
+		if raw, err := sess.dial(ctx, "tcp", host, port); err == nil && probeTLSService(ctx, raw, host, &res) {
+			return res
+		}
+	}
+	fingerprintService(&res, reply)
+	return res
+}
+
+// exchangeBanner returns what the service on conn sends. With a nil probe it
+// first waits for a greeting and sends an HTTP request if there is none.
+func exchangeBanner(conn net.Conn, probe []byte) []byte {
+	if probe == nil {
+		if reply := readBanner(conn); len(reply) > 0 {
+			return reply
+		}
+		probe = httpProbe
+	}
+	conn.SetWriteDeadline(time.Now().Add(bannerTimeout))
+	if _, err := conn.Write(probe); err != nil {
+		return nil
+	}
+	return readBanner(conn)
+}
+
+// readBanner reads what arrives within bannerTimeout, up to maxBannerSize.
+func readBanner(conn net.Conn) []byte {
+	conn.SetReadDeadline(time.Now().Add(bannerTimeout))
+	buf := make([]byte, maxBannerSize)
+	n := 0
+	for n < len(buf) {
+		m, err := conn.Read(buf[n:])
+		n += m
+		if err != nil {
+			break
+		}
+		// Most greetings fit in one segment; stop waiting once a line is in.
+		if bytes.Contains(buf[:n], []byte("\n")) && !bytes.HasPrefix(buf[:n], []byte("HTTP/")) {
+			break
+		}
+	}
+	return buf[:n]
+}
+
+// probeTLSService completes a TLS handshake over raw and fingerprints the
+// service inside. It reports whether the port speaks TLS. raw is closed.
+func probeTLSService(ctx context.Context, raw net.Conn, host string, res *PortResult) bool {
+	serverName := host
+	if net.ParseIP(host) != nil {
+		serverName = ""
+	}
+	conn := tls.Client(raw, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(bannerTimeout))
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return false
+	}
+	res.TLS = true
+	fingerprintService(res, exchangeBanner(conn, nil))
+	if res.Service == "http" {
+		res.Service = "https"
+	}
+	return true
+}
+
+// fingerprintService names the service, product and version from the first
+// reply of a port.
+func fingerprintService(res *PortResult, reply []byte) {
+	if len(reply) == 0 {
+		return
+	}
+	res.Banner = printableBanner(reply)
+	text := string(reply)
+
+	switch {
+	case sshBanner.MatchString(text):
+		res.Service = "ssh"
+		software := sshBanner.FindStringSubmatch(text)[1]
+		res.Product, res.Version, _ = strings.Cut(software, "_")
+	case ftpSMTPBanner.MatchString(text):
+		upper := strings.ToUpper(text)
+		switch {
+		case strings.Contains(upper, "FTP") || res.Service == "ftp":
+			res.Service = "ftp"
+		case strings.Contains(upper, "SMTP") || strings.Contains(upper, "MAIL") || res.Service == "" || strings.HasPrefix(res.Service, "smtp") || res.Service == "submission":
+			if !strings.HasPrefix(res.Service, "smtp") && res.Service != "submission" {
+				res.Service = "smtp"
+			}
+		}
+		matchProduct(res, text)
+	case strings.HasPrefix(text, "+OK"):
+		res.Service = "pop3"
+		matchProduct(res, text)
+	case strings.HasPrefix(text, "* OK"):
+		res.Service = "imap"
+		matchProduct(res, text)
+	case vncBanner.MatchString(text):
+		res.Service = "vnc"
+		res.Product = "RFB"
+		res.Version = vncBanner.FindStringSubmatch(text)[1]
+	case strings.HasPrefix(text, "HTTP/"):
+		res.Service = "http"
+		if resp, err := readHTTPHead(reply); err == nil {
+			res.Product, res.Version = splitProduct(resp)
+		}
+	case redisVersion.MatchString(text) || strings.HasPrefix(text, "-NOAUTH") || strings.HasPrefix(text, "-DENIED"):
+		res.Service = "redis"
+		res.Product = "Redis"
+		if m := redisVersion.FindStringSubmatch(text); m != nil {
+			res.Version = m[1]
+		}
+	case strings.HasPrefix(text, "VERSION "):
+		res.Service = "memcached"
+		res.Product = "memcached"
+		res.Version = strings.TrimSpace(strings.TrimPrefix(strings.SplitN(text, "\n", 2)[0], "VERSION "))
+	case res.Service == "postgresql" && (text == "S" || text == "N" || text[0] == 'E'):
+		res.Product = "PostgreSQL"
+	case len(reply) > 5 && reply[2] == 0 && reply[3] == 0 && (reply[4] == 0x0a || reply[4] == 0xff):
+		// MySQL handshake: 3-byte length, sequence id 0, then protocol 10
+		// and a NUL-terminated server version, or an error packet.
+		res.Service = "mysql"
+		res.Product = "MySQL"
+		// The protocol byte is a newline; show the text after the header.
+		if reply[4] == 0xff && len(reply) > 7 {
+			res.Banner = printableBanner(reply[7:]) // after the error code
+		} else {
+			res.Banner = printableBanner(reply[5:])
+		}
+		if reply[4] == 0x0a {
+			version, _, _ := strings.Cut(string(reply[5:]), "\x00")
+			if strings.Contains(version, "MariaDB") {
+				res.Product = "MariaDB"
+				version = strings.TrimPrefix(version, "5.5.5-") // compatibility prefix
+				version, _, _ = strings.Cut(version, "-MariaDB")
+			}
+			res.Version = version
+		}
+	}
+}
+
+func matchProduct(res *PortResult, text string) {
+	if m := productVersion.FindStringSubmatch(text); m != nil {
+		res.Product, res.Version = m[1], m[2]
+	}
+}
+
+// readHTTPHead returns the Server header of a raw HTTP response.
+func readHTTPHead(reply []byte) (string, error) {
+	r := bufio.NewReader(bytes.NewReader(reply))
+	if _, err := r.ReadString('\n'); err != nil {
+		return "", err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" || err != nil {
+			return "", err
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Server") {
+			return strings.TrimSpace(value), nil
+		}
+	}
+}
+
+// splitProduct splits a Server header such as "nginx/1.18.0 (Ubuntu)".
+func splitProduct(server string) (string, string) {
+	first, _, _ := strings.Cut(server, " ")
+	product, version, _ := strings.Cut(first, "/")
+	return product, version
+}
+
+// printableBanner returns the first line of a reply for display, with
+// non-printable bytes replaced.
+func printableBanner(reply []byte) string {
+	line := reply
+	if i := bytes.IndexAny(reply, "\r\n"); i >= 0 {
+		line = reply[:i]
+	}
+	if len(line) > 200 {
+		line = line[:200]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '.'
+		}
+		return r
+	}, string(line))
+}
+
+func writePortReport(b *strings.Builder, ports []PortResult) {
+	b.WriteString("\nOpen Ports:\n")
+	for _, p := range ports {
+		fmt.Fprintf(b, "- %d/%s", p.Port, p.Protocol)
+		if p.Service != "" {
+			fmt.Fprintf(b, " %s", p.Service)
+		}
+		if software := strings.TrimSpace(p.Product + " " + p.Version); software != "" {
+			fmt.Fprintf(b, " (%s)", software)
+		}
+		if p.TLS {
+			b.WriteString(" [TLS]")
+		}
+		if p.Banner != "" {
+			fmt.Fprintf(b, ": %s", p.Banner)
+		}
+		b.WriteString("\n")
+	}
+}
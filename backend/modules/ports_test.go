@@ -0,0 +1,236 @@
+package modules
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFingerprintService(t *testing.T) {
+	mysqlGreeting := append([]byte{0x4a, 0x00, 0x00, 0x00, 0x0a}, "8.0.35\x00\x08\x00\x00\x00abcdefgh\x00"...)
+	mariaDBGreeting := append([]byte{0x59, 0x00, 0x00, 0x00, 0x0a}, "5.5.5-10.6.12-MariaDB-0ubuntu0.22.04.1\x00\x2c\x00\x00\x00"...)
+	mysqlDenied := append([]byte{0x45, 0x00, 0x00, 0x00, 0xff, 0x6a, 0x04}, "Host '192.0.2.1' is not allowed to connect to this MySQL server"...)
+
+	tests := []struct {
+		name    string
+		port    int
+		reply   []byte
+		service string
+		product string
+		version string
+	}{
+		{"openssh", 22, []byte("SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6\r\n"), "ssh", "OpenSSH", "8.9p1"},
+		{"dropbear on another port", 2222, []byte("SSH-2.0-dropbear_2020.81\r\n"), "ssh", "dropbear", "2020.81"},
+		{"postfix", 25, []byte("220 mail.example.com ESMTP Postfix (Ubuntu)\r\n"), "smtp", "Postfix", ""},
+		{"exim submission", 587, []byte("220 mx.example.com ESMTP Exim 4.96 Mon, 02 Oct 2023 10:00:00 +0000\r\n"), "submission", "Exim", "4.96"},
+		{"smtp on another port", 2525, []byte("220 relay.example.com ESMTP Sendmail 8.15.2/8.15.2; ready\r\n"), "smtp", "Sendmail", "8.15.2"},
+		{"vsftpd", 21, []byte("220 (vsFTPd 3.0.5)\r\n"), "ftp", "vsFTPd", "3.0.5"},
+		{"mysql", 3306, mysqlGreeting, "mysql", "MySQL", "8.0.35"},
+		{"mariadb", 3307, mariaDBGreeting, "mysql", "MariaDB", "10.6.12"},
+		{"mysql host denied", 3306, mysqlDenied, "mysql", "MySQL", ""},
+		{"redis info", 6379, []byte("$3785\r\n# Server\r\nredis_version:7.0.11\r\nredis_git_sha1:00000000\r\n"), "redis", "Redis", "7.0.11"},
+		{"redis with auth", 6380, []byte("-NOAUTH Authentication required.\r\n"), "redis", "Redis", ""},
+		{"postgres without ssl", 5432, []byte("N"), "postgresql", "PostgreSQL", ""},
+		{"postgres with ssl", 5432, []byte("S"), "postgresql", "PostgreSQL", ""},
+		{"nginx", 8080, []byte("HTTP/1.1 200 OK\r\nServer: nginx/1.18.0 (Ubuntu)\r\nContent-Length: 0\r\n\r\n"), "http", "nginx", "1.18.0"},
+		{"memcached", 11211, []byte("VERSION 1.6.21\r\n"), "memcached", "memcached", "1.6.21"},
+		{"unknown", 4444, []byte{0x00, 0x01, 0x02}, "", "", ""},
+	}
+	for _, tt := range tests {
+		res := PortResult{Port: tt.port, Protocol: "tcp", Service: wellKnownPorts[tt.port]}
+		fingerprintService(&res, tt.reply)
+		if res.Service != tt.service || res.Product != tt.product || res.Version != tt.version {
+			t.Errorf("%s: got %q %q %q, want %q %q %q", tt.name, res.Service, res.Product, res.Version, tt.service, tt.product, tt.version)
+		}
+		if res.Banner == "" {
+			t.Errorf("%s: no banner", tt.name)
+		}
+	}
+}
+
+func TestServiceBanners(t *testing.T) {
+	tests := []struct {
+		reply  []byte
+		banner string
+	}{
+		{[]byte("SSH-2.0-OpenSSH_9.6\r\nsecond line"), "SSH-2.0-OpenSSH_9.6"},
+		{[]byte("\x4a\x00\x00\x00\x0a8.0.35\x00abc\x01"), "8.0.35.abc."},
+		{append([]byte{0x45, 0x00, 0x00, 0x00, 0xff, 0x6a, 0x04}, "Host is blocked"...), "Host is blocked"},
+	}
+	for _, tt := range tests {
+		res := PortResult{}
+		fingerprintService(&res, tt.reply)
+		if res.Banner != tt.banner {
+			t.Errorf("banner = %q, want %q", res.Banner, tt.banner)
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int // nil when the spec is invalid
+	}{
+		{"443,22,80", []int{22, 80, 443}},
+		{"8000-8003,8001,22", []int{22, 8000, 8001, 8002, 8003}},
+		{" 21 - 23 , 25 ", []int{21, 22, 23, 25}},
+		{"65535", []int{65535}},
+		{"databases,6379,3306", mustParsePorts(portProfiles["databases"])},
+		{"abc", nil},
+		{"0", nil},
+		{"70000", nil},
+		{"100-90", nil},
+		{"1-", nil},
+		{"80,,", []int{80}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := parsePorts(tt.spec)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.spec, got, err, tt.want)
+		}
+	}
+
+	web, err := parsePorts("WEB")
+	if err != nil || !containsInt(web, 80) || !containsInt(web, 443) || !containsInt(web, 8080) {
+		t.Errorf("web profile: %v, %v", web, err)
+	}
+	both, _ := parsePorts("web,top-100")
+	top, _ := parsePorts("top-100")
+	if len(both) >= len(web)+len(top) {
+		t.Errorf("profiles not deduplicated: %d ports from %d and %d", len(both), len(web), len(top))
+	}
+	if full, err := parsePorts("full"); err != nil || len(full) != 65535 || full[0] != 1 {
+		t.Errorf("full profile: %d ports, %v", len(full), err)
+	}
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// greetingListeners starts n TCP services that greet every client like an
+// SSH server. They count the connections they accept and the most that were
+// waiting for their greeting at once.
+type greetingListeners struct {
+	ports    []int
+	mu       sync.Mutex
+	accepted map[int]int
+	open     int
+	maxOpen  int
+}
+
+func newGreetingListeners(t *testing.T, n int) *greetingListeners {
+	t.Helper()
+	g := &greetingListeners{accepted: make(map[int]int)}
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		port := ln.Addr().(*net.TCPAddr).Port
+		g.ports = append(g.ports, port)
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				g.mu.Lock()
+				g.accepted[port]++
+				g.open++
+				g.maxOpen = max(g.maxOpen, g.open)
+				g.mu.Unlock()
+				go func() {
+					// Hold the greeting back a little so that connections
+					// overlap. Until it is sent the client is surely waiting.
+					time.Sleep(20 * time.Millisecond)
+					g.mu.Lock()
+					g.open--
+					g.mu.Unlock()
+					conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+					io.Copy(io.Discard, conn)
+					conn.Close()
+				}()
+			}
+		}()
+	}
+	return g
+}
+
+func TestScanPortsPerHostConcurrency(t *testing.T) {
+	g := newGreetingListeners(t, 8)
+	sess := newScanSession(1000, 0)
+	defer sess.close()
+	sess.ports = g.ports
+	sess.portWorkers = 2
+
+	// Two names of one server scanned at once share its slots.
+	var wg sync.WaitGroup
+	results := make([][]PortResult, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = scanPorts(context.Background(), sess, "127.0.0.1")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, res := range results {
+		if len(res) != len(g.ports) {
+			t.Fatalf("%d open ports, want %d", len(res), len(g.ports))
+		}
+		for _, p := range res {
+			if p.Service != "ssh" || p.Product != "OpenSSH" {
+				t.Errorf("port %d: %+v", p.Port, p)
+			}
+		}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.maxOpen > sess.portWorkers {
+		t.Errorf("%d connections open at once, want at most %d", g.maxOpen, sess.portWorkers)
+	}
+	for _, port := range g.ports {
+		if g.accepted[port] != 2 {
+			t.Errorf("port %d: %d connections, want one per scan", port, g.accepted[port])
+		}
+	}
+}
+
+func TestAcquirePortSlotCancelled(t *testing.T) {
+	sess := newScanSession(1000, 0)
+	sess.portWorkers = 1
+	release, err := sess.acquirePortSlot(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := sess.acquirePortSlot(ctx, "127.0.0.1"); err == nil {
+		t.Error("second slot granted while the first is held")
+	}
+	release()
+	if release, err := sess.acquirePortSlot(context.Background(), "127.0.0.1"); err != nil {
+		t.Error(err)
+	} else {
+		release()
+	}
+}
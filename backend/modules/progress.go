@@ -2,48 +2,184 @@ package modules
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-type ProgressUpdate struct {
+// Job event types.
+const (
+	EventJobStarted  = "job_started"
+	EventJobReset    = "job_reset" // starts a log rebuilt from the stored results: drop what was received, the whole job follows
+	EventHostStarted = "host_started"
+	EventHostResult  = "host_result" // one AnalysisResult or URLAnalysisResult, as soon as the target is done
+	EventFinding     = "finding"     // each finding of a host, after its host_result
+	EventProgress    = "progress"    // status messages outside the per-host events, e.g. enumeration
+	EventJobPaused   = "job_paused"
+	EventJobResumed  = "job_resumed"
+	EventJobDone     = "job_done"   // completed or cancelled
+	EventJobFailed   = "job_failed" // timed out or interrupted
+)
+
+// JobEvent is one entry of the event log of a job. Seq numbers start at 1
+// and have no gaps, so a client that reconnects with ?since=<last seq> gets
+// exactly what it missed. A log rebuilt from the stored results continues
+// after the last seq of the original and starts with job_reset, since the
+// client cannot tell which of its events it already has; a client that
+// received the final event of the original gets nothing from it.
+type JobEvent struct {
+	Seq      int         `json:"seq"`
+	Type     string      `json:"type"`
 	JobID    string      `json:"jobId"`
+	Time     time.Time   `json:"time"`
 	Progress int         `json:"progress"`
-	Message  string      `json:"message"`
-	Results  interface{} `json:"results,omitempty"`
-	IsFinal  bool        `json:"isFinal"`
+	Message  string      `json:"message,omitempty"`
+	Host     string      `json:"host,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Finding  *Finding    `json:"finding,omitempty"`
+	Status   string      `json:"status,omitempty"` // job_done and job_failed
+	Final    bool        `json:"final"`            // no events follow
+}
+
+// ProgressFunc receives a report after each target of a scan is processed.
+type ProgressFunc func(processed, total int, message string)
+
+// eventLogRetention is how long the log of a finished job is kept for
+// clients that reconnect. Afterwards it is rebuilt from the stored results.
+const eventLogRetention = time.Hour
+
+type eventLog struct {
+	events []JobEvent
+	base   int // last seq of the original log for a rebuilt one, else 0
 }
 
-// In-memory storage for final subdomain results
 var (
-	subdomainResults   = make(map[string][]AnalysisResult) // jobID -> results
-	subdomainResultsMu sync.Mutex
+	clients   = make(map[string][]*eventQueue)
+	eventLogs = make(map[string]*eventLog)
+	mu        sync.Mutex
 )
 
-var (
-	clients = make(map[string][]*websocket.Conn)
-	mu      sync.Mutex
+// eventQueueSize bounds the events waiting to be written to one client.
+const eventQueueSize = 1024
 
-	// Job state management
-	jobStates   = make(map[string]string) // jobID -> "running" or "paused"
-	jobStatesMu sync.Mutex
-)
+// clientWriteWait is how long a write to a WebSocket client may take before
+// the client is dropped.
+const clientWriteWait = 10 * time.Second
+
+// eventQueue holds the events of a job waiting to be written to one client,
+// so that publishing never waits for the network. Once the queue is half
+// full, events that only report progress are dropped: the next event
+// repeats it. A client whose queue fills up is disconnected and resumes from
+// the last seq it received.
+type eventQueue struct {
+	events  chan JobEvent
+	closed  bool // guarded by mu, like removed
+	removed bool // unsubscribed, as opposed to closed because it filled up
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{events: make(chan JobEvent, eventQueueSize)}
+}
+
+// send queues ev without blocking. The caller holds mu.
+func (q *eventQueue) send(ev JobEvent) {
+	if q.closed {
+		return
+	}
+	if (ev.Type == EventProgress || ev.Type == EventHostStarted) && len(q.events) >= cap(q.events)/2 {
+		return
+	}
+	select {
+	case q.events <- ev:
+	default:
+		q.close()
+	}
+}
+
+// close ends the queue. The caller holds mu.
+func (q *eventQueue) close() {
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+}
+
+// SubscribeClient streams the events of the job after seq since to conn,
+// from a goroutine of its own. The returned function unsubscribes it and
+// must be called once the connection is closed.
+func SubscribeClient(jobID string, since int, conn *websocket.Conn) (unsubscribe func()) {
+	q := newEventQueue()
+	backlog := subscribe(jobID, since, q)
+	go writeEvents(conn, backlog, q)
+	return func() { removeClient(jobID, q) }
+}
+
+// writeEvents writes the backlog and then the queued events to conn. If the
+// client is too slow to keep up it is sent a close frame and disconnected.
+func writeEvents(conn *websocket.Conn, backlog []JobEvent, q *eventQueue) {
+	for _, ev := range backlog {
+		if writeEvent(conn, ev) != nil {
+			conn.Close()
+			return
+		}
+	}
+	for ev := range q.events {
+		if writeEvent(conn, ev) != nil {
+			conn.Close()
+			return
+		}
+	}
+	mu.Lock()
+	overflowed := q.closed && !q.removed
+	mu.Unlock()
+	if overflowed {
+		log.Printf("WebSocket client too slow, disconnecting")
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow, reconnect with ?since"), time.Now().Add(clientWriteWait))
+		conn.Close()
+	}
+}
 
-func RegisterClient(jobID string, conn *websocket.Conn) {
+// subscribe returns the events of the job after seq since and registers q
+// for the ones that follow, so that none is missed or sent twice.
+func subscribe(jobID string, since int, q *eventQueue) []JobEvent {
 	mu.Lock()
+	l, ok := eventLogs[jobID]
+	if !ok {
+		// Rebuilding reads the store, which must not hold up publishers.
+		mu.Unlock()
+		rebuilt := loadEventLog(jobID)
+		mu.Lock()
+		// An event may have been published, or the log rebuilt by another
+		// client, in the meantime.
+		if l, ok = eventLogs[jobID]; !ok && rebuilt != nil {
+			l = rebuilt
+			eventLogs[jobID] = l
+			if len(l.events) > 0 {
+				expireEventLog(jobID, l)
+			}
+		}
+	}
 	defer mu.Unlock()
-	clients[jobID] = append(clients[jobID], conn)
+	clients[jobID] = append(clients[jobID], q)
+	if l == nil {
+		return nil
+	}
+	return l.since(since)
 }
 
-func UnregisterClient(jobID string, conn *websocket.Conn) {
+// removeClient unregisters q and ends it.
+func removeClient(jobID string, q *eventQueue) {
 	mu.Lock()
 	defer mu.Unlock()
+	q.removed = true
+	q.close()
 	if conns, ok := clients[jobID]; ok {
 		for i, c := range conns {
-			if c == conn {
-				// Corrected slice removal logic
+			if c == q {
 				clients[jobID] = append(conns[:i], conns[i+1:]...)
 				break
 			}
@@ -54,64 +190,206 @@ func UnregisterClient(jobID string, conn *websocket.Conn) {
 	}
 }
 
-func BroadcastProgress(jobID string, progress int, message string) {
-	broadcast(jobID, ProgressUpdate{
-		JobID:    jobID,
-		Progress: progress,
-		Message:  message,
-		IsFinal:  false,
-	})
+// since returns the events after seq since. The caller holds mu.
+func (l *eventLog) since(since int) []JobEvent {
+	if l.base > 0 && since == l.base {
+		return nil // the client saw the original log end
+	}
+	var events []JobEvent
+	for _, ev := range l.events {
+		if ev.Seq > since {
+			events = append(events, ev)
+		}
+	}
+	return events
 }
 
-func BroadcastFinalResults(jobID string, results interface{}) {
-	broadcast(jobID, ProgressUpdate{
-		JobID:    jobID,
-		Progress: 100,
-		Message:  "Analysis complete. Results attached.",
-		Results:  results,
-		IsFinal:  true,
-	})
+// loadEventLog returns a log for a job that has none in memory: empty for a
+// job that has not published yet, rebuilt from the stored results for one
+// that finished before (e.g. before a restart). It returns nil for unknown
+// jobs.
+func loadEventLog(jobID string) *eventLog {
+	job, err := getStore().GetJob(jobID)
+	if err != nil {
+		return nil
+	}
+	l := &eventLog{}
+	if job.Status != JobStatusRunning && job.Status != JobStatusPaused {
+		l.events = summaryEvents(job)
+		l.base = job.EventSeq
+	}
+	return l
 }
 
-func broadcast(jobID string, update ProgressUpdate) {
+// dropEventLog forgets the events of a deleted job.
+func dropEventLog(jobID string) {
 	mu.Lock()
 	defer mu.Unlock()
-	messageBytes, err := json.Marshal(update)
-	if err != nil {
-		log.Printf("Error marshalling progress update: %v", err)
-		return
+	delete(eventLogs, jobID)
+}
+
+// summaryEvents rebuilds the events of a finished job from its stored
+// results. They are numbered after job.EventSeq, the last seq of the
+// original log, so that a client that reconnects after any event of it
+// receives the whole summary, starting with job_reset.
+func summaryEvents(job Job) []JobEvent {
+	events := []JobEvent{{Type: EventJobReset, Message: "Results reloaded."}}
+	add := func(host string, result interface{}, findings []Finding) {
+		events = append(events, JobEvent{Type: EventHostResult, Host: host, Result: result})
+		for i := range findings {
+			events = append(events, JobEvent{Type: EventFinding, Host: host, Finding: &findings[i]})
+		}
+	}
+	switch job.Kind {
+	case JobKindSubdomain:
+		results, _ := GetSubdomainResults(job.ID)
+		for _, r := range results {
+			add(r.Subdomain, r, r.Findings)
+		}
+	case JobKindURL:
+		results, _ := GetURLResults(job.ID)
+		for _, r := range results {
+			add(r.URL, r, r.Findings)
+		}
+	}
+	events = append(events, jobEndEvent(job.Status, percent(job.Processed, job.Total)))
+	for i := range events {
+		events[i].Seq = job.EventSeq + i + 1
+		events[i].JobID = job.ID
+		events[i].Time = job.CreatedAt
+		if events[i].Type != EventJobReset {
+			events[i].Progress = percent(job.Processed, job.Total)
+		}
 	}
+	if job.FinishedAt != nil {
+		events[len(events)-1].Time = *job.FinishedAt
+	}
+	return events
+}
 
-	if conns, ok := clients[jobID]; ok {
-		for _, conn := range conns {
-			if err := conn.WriteMessage(websocket.TextMessage, messageBytes); err != nil {
-				log.Printf("Error writing to WebSocket: %v", err)
-			}
+// expireEventLog drops l after eventLogRetention unless it was replaced.
+// The caller holds mu.
+func expireEventLog(jobID string, l *eventLog) {
+	time.AfterFunc(eventLogRetention, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if eventLogs[jobID] == l {
+			delete(eventLogs, jobID)
 		}
+	})
+}
+
+// percent returns processed as a percentage of total, treating an empty job
+// as complete.
+func percent(processed, total int) int {
+	if total == 0 {
+		return 100
 	}
+	return (processed * 100) / total
 }
 
-func SetJobState(jobID, state string) {
-	jobStatesMu.Lock()
-	defer jobStatesMu.Unlock()
-	jobStates[jobID] = state
+func BroadcastJobStarted(jobID string) {
+	publish(jobID, JobEvent{Type: EventJobStarted, Message: "Analysis started."})
 }
 
-func GetJobState(jobID string) string {
-	jobStatesMu.Lock()
-	defer jobStatesMu.Unlock()
-	return jobStates[jobID]
+func BroadcastProgress(jobID string, progress int, message string) {
+	publish(jobID, JobEvent{Type: EventProgress, Progress: progress, Message: message})
 }
 
-func StoreSubdomainResults(jobID string, results []AnalysisResult) {
-	subdomainResultsMu.Lock()
-	defer subdomainResultsMu.Unlock()
-	subdomainResults[jobID] = results
+func BroadcastHostStarted(jobID string, progress int, host string) {
+	publish(jobID, JobEvent{Type: EventHostStarted, Progress: progress, Host: host})
 }
 
-func GetSubdomainResults(jobID string) ([]AnalysisResult, bool) {
-	subdomainResultsMu.Lock()
-	defer subdomainResultsMu.Unlock()
-	results, ok := subdomainResults[jobID]
-	return results, ok
+// BroadcastHostResult streams the result of one target followed by its
+// findings.
+func BroadcastHostResult(jobID string, progress int, message, host string, result interface{}, findings []Finding) {
+	publish(jobID, JobEvent{Type: EventHostResult, Progress: progress, Message: message, Host: host, Result: result})
+	for i := range findings {
+		publish(jobID, JobEvent{Type: EventFinding, Progress: progress, Host: host, Finding: &findings[i]})
+	}
+}
+
+// BroadcastJobState reports a job being paused or resumed.
+func BroadcastJobState(jobID, state string) {
+	ev := JobEvent{Type: EventJobResumed, Message: "Analysis resumed."}
+	if state == JobStatusPaused {
+		ev = JobEvent{Type: EventJobPaused, Message: "Analysis paused."}
+	}
+	if job, err := getStore().GetJob(jobID); err == nil {
+		job = liveJob(job)
+		ev.Progress = percent(job.Processed, job.Total)
+	}
+	publish(jobID, ev)
+}
+
+// BroadcastJobEnd is the last event of a job. The results were streamed as
+// host_result events and are not repeated.
+func BroadcastJobEnd(jobID string, progress int, status string) {
+	publish(jobID, jobEndEvent(status, progress))
+}
+
+func jobEndEvent(status string, progress int) JobEvent {
+	ev := JobEvent{Type: EventJobDone, Progress: progress, Status: status, Final: true}
+	switch status {
+	case JobStatusCompleted:
+		ev.Progress = 100
+		ev.Message = "Analysis complete."
+	case JobStatusTimedOut, JobStatusInterrupted:
+		ev.Type = EventJobFailed
+		fallthrough
+	default:
+		ev.Message = fmt.Sprintf("Analysis %s. Partial results were streamed.", strings.ReplaceAll(status, "_", " "))
+	}
+	return ev
+}
+
+// publish appends ev to the log of the job and sends it to its clients.
+// Scans run without a job (jobID "") have no log.
+func publish(jobID string, ev JobEvent) {
+	if jobID == "" {
+		return
+	}
+	seq := appendEvent(jobID, ev)
+	if ev.Final {
+		// Stored outside mu, for summaryEvents to continue from.
+		updateJob(jobID, func(job *Job) { job.EventSeq = seq })
+	}
+}
+
+// appendEvent logs ev, sends it to the clients of the job and returns its
+// seq.
+func appendEvent(jobID string, ev JobEvent) int {
+	mu.Lock()
+	defer mu.Unlock()
+	l, ok := eventLogs[jobID]
+	if !ok {
+		l = &eventLog{}
+		eventLogs[jobID] = l
+	}
+	ev.Seq = len(l.events) + 1
+	ev.JobID = jobID
+	ev.Time = time.Now().UTC()
+	l.events = append(l.events, ev)
+	if ev.Final {
+		expireEventLog(jobID, l)
+	}
+
+	for _, c := range clients[jobID] {
+		c.send(ev)
+	}
+	return ev.Seq
+}
+
+func writeEvent(conn *websocket.Conn, ev JobEvent) error {
+	messageBytes, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Error marshalling job event: %v", err)
+		return nil
+	}
+	conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+	if err := conn.WriteMessage(websocket.TextMessage, messageBytes); err != nil {
+		log.Printf("Error writing to WebSocket: %v", err)
+		return err
+	}
+	return nil
 }
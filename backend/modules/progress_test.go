@@ -0,0 +1,69 @@
+package modules
+
+import "testing"
+
+func TestRebuiltEventLogContinuesSeq(t *testing.T) {
+	SetStore(NewMemoryStore())
+	job := createJob(JobKindURL, "", []string{"https://a.example.com"}, nil)
+	BroadcastJobStarted(job.ID)
+	BroadcastProgress(job.ID, 0, "working")
+	result := URLAnalysisResult{URL: "https://a.example.com", Findings: []Finding{{Title: "x", Severity: SeverityHigh}}}
+	BroadcastHostResult(job.ID, 100, "done", result.URL, result, result.Findings)
+	StoreURLResults(job.ID, []URLAnalysisResult{result})
+	finishJob(job.ID, JobStatusCompleted, 1)
+	BroadcastJobEnd(job.ID, 100, JobStatusCompleted)
+
+	live := subscribe(job.ID, 0, newEventQueue())
+	last := live[len(live)-1]
+	if !last.Final || last.Seq != 5 {
+		t.Fatalf("last live event = %+v", last)
+	}
+
+	// As after a restart: the log is gone and is rebuilt from the results.
+	// A client that saw the job end gets nothing more.
+	dropEventLog(job.ID)
+	if again := subscribe(job.ID, last.Seq, newEventQueue()); len(again) != 0 {
+		t.Errorf("up to date client got %d rebuilt events", len(again))
+	}
+
+	// One that missed events gets the whole job again, after a reset, and
+	// with seqs that continue the original log.
+	rebuilt := subscribe(job.ID, 3, newEventQueue())
+	var types []string
+	for i, ev := range rebuilt {
+		if ev.Seq != last.Seq+i+1 {
+			t.Errorf("event %d has seq %d, want %d", i, ev.Seq, last.Seq+i+1)
+		}
+		types = append(types, ev.Type)
+	}
+	want := []string{EventJobReset, EventHostResult, EventFinding, EventJobDone}
+	if len(types) != len(want) {
+		t.Fatalf("rebuilt events = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("rebuilt events = %v, want %v", types, want)
+			break
+		}
+	}
+
+	// Replaying what a client kept plus what it got after the reset yields
+	// each host and finding once.
+	seen := make(map[string]int)
+	for _, ev := range append(live[:3], rebuilt...) {
+		switch ev.Type {
+		case EventJobReset:
+			seen = make(map[string]int)
+		case EventHostResult, EventFinding:
+			seen[ev.Type+" "+ev.Host]++
+		}
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("%s received %d times", key, n)
+		}
+	}
+	if len(seen) != 2 {
+		t.Errorf("state after replay = %v", seen)
+	}
+}
@@ -0,0 +1,132 @@
+package modules
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// scanLimiter paces every outgoing request of a job with a token bucket.
+// An optional second bucket per resolved IP keeps many subdomains that share
+// one server from hammering it.
+type scanLimiter struct {
+	global  *rate.Limiter
+	perHost rate.Limit
+
+	mu       sync.Mutex
+	hosts    map[string]*rate.Limiter // IP -> limiter
+	ips      map[string]string        // hostname -> IP
+	resolver *net.Resolver
+}
+
+// newScanLimiter builds a limiter allowing rps requests per second overall
+// and perHostRPS per target IP. A non-positive perHostRPS disables the
+// per-host bucket.
+func newScanLimiter(rps, perHostRPS float64) *scanLimiter {
+	l := &scanLimiter{
+		global:   rate.NewLimiter(rate.Limit(rps), 1),
+		hosts:    make(map[string]*rate.Limiter),
+		ips:      make(map[string]string),
+		resolver: net.DefaultResolver,
+	}
+	if perHostRPS > 0 {
+		l.perHost = rate.Limit(perHostRPS)
+	}
+	return l
+}
+
+// Wait blocks until a request to host may be sent or ctx is done.
+func (l *scanLimiter) Wait(ctx context.Context, host string) error {
+	if err := l.global.Wait(ctx); err != nil {
+		return err
+	}
+	if l.perHost == 0 {
+		return nil
+	}
+	return l.hostLimiter(ctx, host).Wait(ctx)
+}
+
+func (l *scanLimiter) hostLimiter(ctx context.Context, host string) *rate.Limiter {
+	key := l.hostKey(ctx, host)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lim, ok := l.hosts[key]
+	if !ok {
+		lim = rate.NewLimiter(l.perHost, 1)
+		l.hosts[key] = lim
+	}
+	return lim
+}
+
+// setResolver makes hostKey resolve names through r, the job's DNS servers.
+func (l *scanLimiter) setResolver(r *net.Resolver) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.resolver = r
+}
+
+// hostKey resolves host to its first IP so that names pointing at the same
+// server share a bucket. Unresolvable names are keyed by themselves.
+func (l *scanLimiter) hostKey(ctx context.Context, host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	l.mu.Lock()
+	ip, ok := l.ips[host]
+	resolver := l.resolver
+	l.mu.Unlock()
+	if ok {
+		return ip
+	}
+	ip = host
+	if addrs, err := resolver.LookupIPAddr(ctx, host); err == nil && len(addrs) > 0 {
+		ip = addrs[0].IP.String()
+	}
+	l.mu.Lock()
+	l.ips[host] = ip
+	l.mu.Unlock()
+	return ip
+}
+
+// limitedTransport makes every HTTP round trip, including redirects, wait for
+// the job's limiter. Once through, the round trip and the read of its body
+// must finish within timeout.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *scanLimiter
+	timeout time.Duration
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context(), req.URL.Hostname()); err != nil {
+		return nil, err
+	}
+	if t.timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &deadlineBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// deadlineBody releases the deadline of its round trip when closed.
+type deadlineBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *deadlineBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
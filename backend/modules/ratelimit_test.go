@@ -0,0 +1,76 @@
+package modules
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionBoundsSlowBodies(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.Scan.HTTPTimeout = 200 * time.Millisecond
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first chunk"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	sess := newScanSession(1000, 0)
+	defer sess.close()
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", srv.URL, nil)
+	resp, err := sess.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBody))
+	if err == nil {
+		t.Error("reading a body that never ends succeeded")
+	}
+	if string(body) != "first chunk" {
+		t.Errorf("body = %q", body)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("read returned after %s, want about the request timeout", elapsed)
+	}
+}
+
+func TestLimiterWaitDoesNotCountAgainstTimeout(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.Scan.HTTPTimeout = 300 * time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// At 2 requests per second every request after the first waits half a
+	// second for the limiter, longer than the request timeout.
+	sess := newScanSession(2, 0)
+	defer sess.close()
+	for i := 0; i < 3; i++ {
+		resp, err := sess.client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "ok" {
+			t.Fatalf("request %d: body %q, %v", i, body, err)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule is a subdomain analysis run automatically on a cron schedule.
+// Each run is diffed against the previous completed one and the changes
+// worth attention are sent as a ScheduleAlert.
+type Schedule struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Cron          string            `json:"cron"` // five fields, or a descriptor such as @daily or @every 12h; server local time
+	Subdomains    []string          `json:"subdomains,omitempty"`
+	RootDomain    string            `json:"rootDomain,omitempty"`
+	Options       map[string]string `json:"options,omitempty"` // form fields of /subdomains/analyze, e.g. isDeepCrawl, requestsPerSecond
+	Enabled       bool              `json:"enabled"`
+	Owner         string            `json:"owner,omitempty"`      // username of the creator, who owns its jobs
+	WebhookURL    string            `json:"webhookUrl,omitempty"` // receives the alerts as JSON
+	Notify        []NotifierConfig  `json:"notify,omitempty"`     // sinks notified of the alerts and of each run
+	CreatedAt     time.Time         `json:"createdAt"`
+	LastRunAt     *time.Time        `json:"lastRunAt,omitempty"`
+	LastJobID     string            `json:"lastJobId,omitempty"`
+	BaselineJobID string            `json:"baselineJobId,omitempty"` // latest completed run, which the next one is diffed against
+	NextRunAt     *time.Time        `json:"nextRunAt,omitempty"`     // not stored
+}
+
+// ScheduleAlert lists what appeared since the previous run of a schedule.
+// Hosts and ports are only reported once there is a previous run.
+type ScheduleAlert struct {
+	ScheduleID    string    `json:"scheduleId"`
+	Schedule      string    `json:"schedule"`
+	JobID         string    `json:"jobId"`
+	BaselineJobID string    `json:"baselineJobId,omitempty"`
+	NewHosts      []string  `json:"newHosts,omitempty"`    // new subdomains and those that became reachable
+	NewPorts      []string  `json:"newPorts,omitempty"`    // "host 22/tcp ssh"
+	NewFindings   []Finding `json:"newFindings,omitempty"` // of high or critical severity
+}
+
+// scheduleRequest is the body of the create and update endpoints.
+type scheduleRequest struct {
+	Name       string            `json:"name"`
+	Cron       string            `json:"cron"`
+	Subdomains []string          `json:"subdomains"`
+	RootDomain string            `json:"rootDomain"`
+	Options    map[string]string `json:"options"`
+	Enabled    *bool             `json:"enabled"` // default true
+	WebhookURL string            `json:"webhookUrl"`
+	Notify     []NotifierConfig  `json:"notify"`
+}
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+var scheduler = struct {
+	mu      sync.Mutex
+	cron    *cron.Cron // nil until StartScheduler
+	entries map[string]cron.EntryID
+	running map[string]bool
+}{entries: make(map[string]cron.EntryID), running: make(map[string]bool)}
+
+// StartScheduler registers the enabled schedules of the store and starts
+// running them. It must be called after SetStore.
+func StartScheduler() error {
+	schedules, err := getStore().ListSchedules()
+	if err != nil {
+		return err
+	}
+	scheduler.mu.Lock()
+	scheduler.cron = cron.New(cron.WithParser(cronParser))
+	scheduler.mu.Unlock()
+	for _, s := range schedules {
+		if err := registerSchedule(s); err != nil {
+			log.Printf("Error registering schedule %s: %v", s.ID, err)
+		}
+	}
+	scheduler.cron.Start()
+	return nil
+}
+
+// StopScheduler stops starting runs. Runs in progress are jobs, aborted by
+// ShutdownJobs.
+func StopScheduler() {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if scheduler.cron != nil {
+		scheduler.cron.Stop()
+	}
+}
+
+// registerSchedule replaces the cron entry of s, if any, with a new one
+// when s is enabled.
+func registerSchedule(s Schedule) error {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if scheduler.cron == nil {
+		return nil
+	}
+	if id, ok := scheduler.entries[s.ID]; ok {
+		scheduler.cron.Remove(id)
+		delete(scheduler.entries, s.ID)
+	}
+	if !s.Enabled {
+		return nil
+	}
+	scheduleID := s.ID
+	id, err := scheduler.cron.AddFunc(s.Cron, func() { runSchedule(scheduleID) })
+	if err != nil {
+		return err
+	}
+	scheduler.entries[s.ID] = id
+	return nil
+}
+
+func unregisterSchedule(id string) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if entry, ok := scheduler.entries[id]; ok {
+		scheduler.cron.Remove(entry)
+		delete(scheduler.entries, id)
+	}
+}
+
+// withNextRun sets the computed NextRunAt of s.
+func withNextRun(s Schedule) Schedule {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if id, ok := scheduler.entries[s.ID]; ok {
+		if next := scheduler.cron.Entry(id).Next; !next.IsZero() {
+			s.NextRunAt = &next
+		}
+	}
+	return s
+}
+
+// scheduleWrites serializes the read-modify-write of schedules, so that
+// the run history saved by a finishing run and an edit of the definition do
+// not overwrite each other.
+var scheduleWrites sync.Mutex
+
+// updateSchedule applies fn to the stored schedule and saves it back.
+func updateSchedule(id string, fn func(s *Schedule)) {
+	_, err := modifySchedule(id, func(s *Schedule) error {
+		fn(s)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error updating schedule %s: %v", id, err)
+	}
+}
+
+// modifySchedule applies fn to the stored schedule and saves it back unless
+// fn fails, returning the saved schedule.
+func modifySchedule(id string, fn func(s *Schedule) error) (Schedule, error) {
+	scheduleWrites.Lock()
+	defer scheduleWrites.Unlock()
+	s, err := getStore().GetSchedule(id)
+	if err != nil {
+		return s, err
+	}
+	if err := fn(&s); err != nil {
+		return s, err
+	}
+	return s, getStore().SaveSchedule(s)
+}
+
+// request returns the analysis request of a run of s.
+func (s Schedule) request() (SubdomainAnalysisRequest, error) {
+	for name := range s.Options {
+		if name == "rootDomain" {
+			return SubdomainAnalysisRequest{}, errors.New("set the root domain with the rootDomain field, not as an option")
+		}
+	}
+	req, err := subdomainRequestFromOptions(s.Options)
+	if err != nil {
+		return req, err
+	}
+	req.Subdomains = s.Subdomains
+	req.RootDomain = s.RootDomain
+	req.NotifySinks = s.sinks()
+	return req, req.validate()
+}
+
+// sinks returns the notifiers of s. The webhook URL only receives alerts.
+func (s Schedule) sinks() []NotifierConfig {
+	sinks := append([]NotifierConfig{}, s.Notify...)
+	if s.WebhookURL != "" {
+		sinks = append(sinks, NotifierConfig{Type: SinkWebhook, URL: s.WebhookURL, Events: []string{NotifyScheduleAlert}})
+	}
+	return sinks
+}
+
+func (s Schedule) validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("a name is required")
+	}
+	if _, err := cronParser.Parse(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression %q: %v", s.Cron, err)
+	}
+	if len(s.Subdomains) == 0 && s.RootDomain == "" {
+		return errors.New("no subdomains or root domain provided")
+	}
+	for _, sink := range s.sinks() {
+		if err := sink.validate(false); err != nil {
+			return err
+		}
+	}
+	_, err := s.request()
+	return err
+}
+
+// runSchedule runs the analysis of a schedule as a job and sends the alerts
+// of the run. A run is skipped while the previous one is still going.
+func runSchedule(id string) {
+	scheduler.mu.Lock()
+	if scheduler.running[id] {
+		scheduler.mu.Unlock()
+		log.Printf("Schedule %s: previous run still in progress, skipping", id)
+		return
+	}
+	scheduler.running[id] = true
+	scheduler.mu.Unlock()
+	defer func() {
+		scheduler.mu.Lock()
+		delete(scheduler.running, id)
+		scheduler.mu.Unlock()
+	}()
+
+	s, err := getStore().GetSchedule(id)
+	if err != nil {
+		log.Printf("Error loading schedule %s: %v", id, err)
+		return
+	}
+	req, err := s.request()
+	if err == nil && req.RootDomain != "" {
+		// The history source reuses only the jobs the owner may see. A
+		// deleted owner's account sees none.
+		owner, _ := getStore().GetUser(s.Owner)
+		req.Enumerators, err = BuildEnumSources(EnumOptions{Sources: req.EnumSources, Resolvers: req.resolverList(), User: owner})
+	}
+	if err != nil {
+		log.Printf("Schedule %s: %v", id, err)
+		return
+	}
+	opts := req.jobOptions()
+	opts["scheduleId"] = s.ID
+	jobID := createJob(JobKindSubdomain, s.Owner, req.Subdomains, opts).ID
+	now := time.Now().UTC()
+	updateSchedule(id, func(s *Schedule) {
+		s.LastRunAt = &now
+		s.LastJobID = jobID
+	})
+	log.Printf("Schedule %s: started job %s", id, jobID)
+
+	ctx := startJobRuntime(jobID, parseJobTimeout(req.Timeout))
+	performSubdomainAnalysis(ctx, req, jobID)
+
+	if job, err := getStore().GetJob(jobID); err != nil || job.Status != JobStatusCompleted {
+		// An incomplete run would show every host it missed as removed.
+		log.Printf("Schedule %s: job %s did not complete, not diffing it", id, jobID)
+		return
+	}
+	results, _ := GetSubdomainResults(jobID)
+	var baseline []AnalysisResult
+	hasBaseline := false
+	if s.BaselineJobID != "" {
+		baseline, hasBaseline = GetSubdomainResults(s.BaselineJobID)
+	}
+	alert := newScheduleAlert(s, jobID, baseline, hasBaseline, results)
+	updateSchedule(id, func(s *Schedule) { s.BaselineJobID = jobID })
+	if len(alert.NewHosts)+len(alert.NewPorts)+len(alert.NewFindings) > 0 {
+		sendScheduleAlert(s, alert)
+	}
+}
+
+// newScheduleAlert collects the hosts, ports and severe findings of results
+// that were not in baseline.
+func newScheduleAlert(s Schedule, jobID string, baseline []AnalysisResult, hasBaseline bool, results []AnalysisResult) ScheduleAlert {
+	alert := ScheduleAlert{ScheduleID: s.ID, Schedule: s.Name, JobID: jobID}
+	if hasBaseline {
+		alert.BaselineJobID = s.BaselineJobID
+		byHost := make(map[string]AnalysisResult, len(results))
+		for _, r := range results {
+			byHost[normalizeHostname(r.Subdomain)] = r
+		}
+		diff := DiffSubdomainResults(baseline, results)
+		for _, host := range diff.Added {
+			alert.NewHosts = append(alert.NewHosts, host)
+			for _, p := range byHost[normalizeHostname(host)].Ports {
+				alert.NewPorts = append(alert.NewPorts, strings.TrimSpace(fmt.Sprintf("%s %d/%s %s", host, p.Port, p.Protocol, p.Service)))
+			}
+		}
+		for _, c := range diff.Changed {
+			if c.Reachability != nil && c.Reachability.To == "reachable" {
+				alert.NewHosts = append(alert.NewHosts, c.Subdomain)
+			}
+			for _, p := range c.OpenedPorts {
+				alert.NewPorts = append(alert.NewPorts, c.Subdomain+" "+p)
+			}
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, r := range baseline {
+		for _, f := range r.Findings {
+			known[f.ID] = true
+		}
+	}
+	for _, r := range results {
+		for _, f := range r.Findings {
+			if severityPriority(f.Severity) == "High" && !known[f.ID] {
+				alert.NewFindings = append(alert.NewFindings, f)
+			}
+		}
+	}
+	return alert
+}
+
+// sendScheduleAlert logs alert and sends it to the global sinks and those
+// of s.
+func sendScheduleAlert(s Schedule, alert ScheduleAlert) {
+	log.Printf("Schedule %s: %d new hosts, %d new ports, %d new high-severity findings in job %s",
+		s.ID, len(alert.NewHosts), len(alert.NewPorts), len(alert.NewFindings), alert.JobID)
+	var msg strings.Builder
+	for _, host := range alert.NewHosts {
+		fmt.Fprintf(&msg, "New host: %s\n", host)
+	}
+	for _, port := range alert.NewPorts {
+		fmt.Fprintf(&msg, "New port: %s\n", port)
+	}
+	msg.WriteString(findingsMessage(alert.NewFindings))
+	dispatch(append(append([]NotifierConfig{}, cfg.Notify.Sinks...), s.sinks()...), Notification{
+		Event:   NotifyScheduleAlert,
+		JobID:   alert.JobID,
+		Kind:    JobKindSubdomain,
+		Status:  JobStatusCompleted,
+		Title:   fmt.Sprintf("Schedule %s: changes in job %s", s.Name, alert.JobID),
+		Message: msg.String(),
+		Alert:   &alert,
+		Time:    time.Now().UTC(),
+	})
+}
+
+// --- API Handlers for Schedules ---
+
+func HandleListSchedules(c *gin.Context) {
+	schedules, err := getStore().ListSchedules()
+	if err != nil {
+		log.Printf("Error listing schedules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list schedules"})
+		return
+	}
+	visible := []Schedule{}
+	for _, s := range schedules {
+		if canAccess(c, s.Owner) {
+			visible = append(visible, withNextRun(s))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": visible})
+}
+
+func HandleCreateSchedule(c *gin.Context) {
+	var req scheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	s := Schedule{ID: uuid.New().String(), Owner: currentUser(c).Username, CreatedAt: time.Now().UTC()}
+	req.apply(&s)
+	if err := s.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := getStore().SaveSchedule(s); err != nil {
+		log.Printf("Error saving schedule %s: %v", s.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save schedule"})
+		return
+	}
+	scheduleSaved(c, s)
+}
+
+func HandleGetSchedule(c *gin.Context) {
+	s, ok := lookupSchedule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, withNextRun(s))
+}
+
+// HandleUpdateSchedule replaces the definition of a schedule. Its run
+// history is kept: the definition is applied to the stored schedule, not to
+// the copy read before the request body, which a run may have updated since.
+func HandleUpdateSchedule(c *gin.Context) {
+	s, ok := lookupSchedule(c)
+	if !ok {
+		return
+	}
+	var req scheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	var invalid error
+	s, err := modifySchedule(s.ID, func(s *Schedule) error {
+		req.apply(s)
+		invalid = s.validate()
+		return invalid
+	})
+	switch {
+	case invalid != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	case errors.Is(err, ErrScheduleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	case err != nil:
+		log.Printf("Error saving schedule %s: %v", s.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save schedule"})
+		return
+	}
+	scheduleSaved(c, s)
+}
+
+// apply sets the user-editable fields of s from the request.
+func (req scheduleRequest) apply(s *Schedule) {
+	s.Name = req.Name
+	s.Cron = strings.TrimSpace(req.Cron)
+	s.Subdomains = req.Subdomains
+	s.RootDomain = req.RootDomain
+	s.Options = req.Options
+	s.Enabled = req.Enabled == nil || *req.Enabled
+	s.WebhookURL = req.WebhookURL
+	s.Notify = req.Notify
+	s.NextRunAt = nil
+}
+
+// scheduleSaved (re)registers a saved schedule with the scheduler and
+// returns it.
+func scheduleSaved(c *gin.Context, s Schedule) {
+	if err := registerSchedule(s); err != nil {
+		log.Printf("Error registering schedule %s: %v", s.ID, err)
+	}
+	c.JSON(http.StatusOK, withNextRun(s))
+}
+
+func HandleDeleteSchedule(c *gin.Context) {
+	s, ok := lookupSchedule(c)
+	if !ok {
+		return
+	}
+	unregisterSchedule(s.ID)
+	if err := getStore().DeleteSchedule(s.ID); err != nil && !errors.Is(err, ErrScheduleNotFound) {
+		log.Printf("Error deleting schedule %s: %v", s.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// HandleRunSchedule starts a run of a schedule now.
+func HandleRunSchedule(c *gin.Context) {
+	s, ok := lookupSchedule(c)
+	if !ok {
+		return
+	}
+	scheduler.mu.Lock()
+	running := scheduler.running[s.ID]
+	scheduler.mu.Unlock()
+	if running {
+		c.JSON(http.StatusConflict, gin.H{"error": "Schedule is already running"})
+		return
+	}
+	go runSchedule(s.ID)
+	c.JSON(http.StatusAccepted, gin.H{"status": "started"})
+}
+
+// lookupSchedule loads the schedule named by the :scheduleID route
+// parameter, writing a 404 response if it does not exist.
+func lookupSchedule(c *gin.Context) (Schedule, bool) {
+	s, err := getStore().GetSchedule(c.Param("scheduleID"))
+	if errors.Is(err, ErrScheduleNotFound) || (err == nil && !canAccess(c, s.Owner)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return Schedule{}, false
+	}
+	if err != nil {
+		log.Printf("Error loading schedule %s: %v", c.Param("scheduleID"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load schedule"})
+		return Schedule{}, false
+	}
+	return s, true
+}
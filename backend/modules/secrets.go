@@ -0,0 +1,313 @@
+package modules
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SecretRule detects one kind of credential in response bodies and scripts.
+// The bundled rules can be extended, replaced by id or disabled with the
+// rule files listed in scan.secretRules.
+type SecretRule struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Pattern    string   `json:"pattern"`            // RE2 syntax; the first submatch, if any, is the secret
+	Keywords   []string `json:"keywords,omitempty"` // the rule only runs on content containing one of them, case-insensitively
+	Entropy    float64  `json:"entropy,omitempty"`  // minimum Shannon entropy of the secret, in bits per character
+	Severity   string   `json:"severity"`           // info, low, medium, high or critical
+	Confidence string   `json:"confidence"`         // low, medium or high
+	Disabled   bool     `json:"disabled,omitempty"` // turns off a bundled rule with the same id
+
+	re *regexp.Regexp
+}
+
+// SecretMatch is a credential found by a rule. The secret itself is never
+// stored, only a redacted form of it.
+type SecretMatch struct {
+	RuleID     string  `json:"RuleID"`
+	Name       string  `json:"Name"`
+	Severity   string  `json:"Severity"`
+	Confidence string  `json:"Confidence"`
+	Value      string  `json:"Value"`    // redacted
+	Snippet    string  `json:"Snippet"`  // surrounding text, with the secret redacted
+	Location   string  `json:"Location"` // URL or file that contained the secret
+	Offset     int     `json:"Offset"`   // byte offset of the secret in the scanned content
+	Length     int     `json:"Length"`
+	Line       int     `json:"Line"`
+	Entropy    float64 `json:"Entropy"`
+}
+
+// secretPlaceholders mark documentation values and templates rather than
+// real credentials.
+var secretPlaceholders = []string{"example", "xxxxxx", "placeholder", "your_", "your-", "changeme", "dummy", "redacted", "******", "<", "${", "{{"}
+
+const secretContext = 24 // bytes of context on each side of a snippet
+
+//go:embed data/secret_rules.json
+var defaultSecretRules []byte
+
+// LoadSecretRules returns the bundled rules merged with the rule files at
+// paths, in order. A rule whose id is already known replaces it.
+func LoadSecretRules(paths []string) ([]SecretRule, error) {
+	rules, err := parseSecretRules(defaultSecretRules)
+	if err != nil {
+		return nil, fmt.Errorf("bundled secret rules: %w", err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		extra, err := parseSecretRules(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, r := range extra {
+			replaced := false
+			for i := range rules {
+				if rules[i].ID == r.ID {
+					rules[i], replaced = r, true
+				}
+			}
+			if !replaced {
+				rules = append(rules, r)
+			}
+		}
+	}
+
+	enabled := rules[:0]
+	for _, r := range rules {
+		if !r.Disabled {
+			enabled = append(enabled, r)
+		}
+	}
+	return enabled, nil
+}
+
+func parseSecretRules(data []byte) ([]SecretRule, error) {
+	var rules []SecretRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing secret rules: %w", err)
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.ID == "" {
+			return nil, fmt.Errorf("secret rule %d: id is required", i)
+		}
+		if r.Disabled {
+			continue
+		}
+		if r.Name == "" || r.Pattern == "" {
+			return nil, fmt.Errorf("secret rule %q: name and pattern are required", r.ID)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("secret rule %q: %w", r.ID, err)
+		}
+		r.re = re
+		if !containsString(severities, r.Severity) {
+			return nil, fmt.Errorf("secret rule %q: unknown severity %q, expected one of %s", r.ID, r.Severity, strings.Join(severities, ", "))
+		}
+		if !containsString(confidences, r.Confidence) {
+			return nil, fmt.Errorf("secret rule %q: unknown confidence %q, expected one of %s", r.ID, r.Confidence, strings.Join(confidences, ", "))
+		}
+		if r.Entropy < 0 {
+			return nil, fmt.Errorf("secret rule %q: entropy must not be negative", r.ID)
+		}
+		for j, kw := range r.Keywords {
+			r.Keywords[j] = strings.ToLower(kw)
+		}
+	}
+	return rules, nil
+}
+
+// secretScanner applies a rule set to content. It is safe for concurrent
+// use.
+type secretScanner struct {
+	rules []SecretRule
+}
+
+// newSecretScanner loads the configured rules. The files are read for every
+// job so that they can be updated without restarting the server.
+func newSecretScanner() *secretScanner {
+	rules, err := LoadSecretRules(cfg.Scan.SecretRules)
+	if err != nil {
+		log.Printf("Error loading secret rules, using the bundled rules: %v", err)
+		rules, _ = LoadSecretRules(nil)
+	}
+	return &secretScanner{rules: rules}
+}
+
+// Scan returns the secrets in content ordered by offset. Each rule reports a
+// given value once.
+func (s *secretScanner) Scan(location string, content []byte) []SecretMatch {
+	if s == nil || len(content) == 0 {
+		return nil
+	}
+	lower := bytes.ToLower(content)
+	lines := lineOffsets(string(content))
+	var matches []SecretMatch
+	seen := make(map[string]bool)
+	for i := range s.rules {
+		r := &s.rules[i]
+		if !r.applies(lower) {
+			continue
+		}
+		for _, loc := range r.re.FindAllSubmatchIndex(content, -1) {
+			start, end := loc[0], loc[1]
+			grouped := len(loc) > 2 && loc[2] >= 0
+			if grouped {
+				start, end = loc[2], loc[3]
+			}
+			value := string(content[start:end])
+			key := r.ID + "\x00" + value
+			if seen[key] || (grouped && isPlaceholderSecret(value)) {
+				continue
+			}
+			entropy := shannonEntropy(value)
+			if entropy < r.Entropy {
+				continue
+			}
+			seen[key] = true
+			matches = append(matches, SecretMatch{
+				RuleID:     r.ID,
+				Name:       r.Name,
+				Severity:   r.Severity,
+				Confidence: r.Confidence,
+				Value:      redactSecret(value),
+				Location:   location,
+				Offset:     start,
+				Length:     end - start,
+				Line:       sort.SearchInts(lines, start+1) + 1,
+				Entropy:    math.Round(entropy*100) / 100,
+			})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Offset < matches[j].Offset })
+	for i := range matches {
+		matches[i].Snippet = secretSnippet(content, matches, matches[i])
+	}
+	return matches
+}
+
+func (r *SecretRule) applies(lower []byte) bool {
+	if len(r.Keywords) == 0 {
+		return true
+	}
+	for _, kw := range r.Keywords {
+		if bytes.Contains(lower, []byte(kw)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPlaceholderSecret(value string) bool {
+	lower := strings.ToLower(value)
+	for _, p := range secretPlaceholders {
+		if strings.Contains(lower, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// shannonEntropy returns the entropy of s in bits per byte. Random tokens
+// score above 3.5, words and repeated characters well below.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(s))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// secretSnippet returns the text around m on one line. Every secret in the
+// window is redacted, not only m, so that the context cannot leak a
+// neighbouring one.
+func secretSnippet(content []byte, matches []SecretMatch, m SecretMatch) string {
+	from, to := m.Offset-secretContext, m.Offset+m.Length+secretContext
+	if from < 0 {
+		from = 0
+	}
+	if to > len(content) {
+		to = len(content)
+	}
+	var b strings.Builder
+	pos := from
+	for _, o := range matches { // ordered by offset
+		start, end := o.Offset, o.Offset+o.Length
+		if end <= pos || start >= to {
+			continue
+		}
+		if start >= pos && end <= to {
+			b.Write(content[pos:start])
+			b.WriteString(o.Value)
+		} else {
+			// Cut by the window or by an overlapping match: mask what shows.
+			if start < pos {
+				start = pos
+			}
+			if end > to {
+				end = to
+			}
+			b.Write(content[pos:start])
+			b.WriteString(strings.Repeat("*", end-start))
+		}
+		pos = end
+	}
+	b.Write(content[pos:to])
+	return strings.Join(strings.Fields(strings.ToValidUTF8(b.String(), "")), " ")
+}
+
+// secretFinding describes an exposed secret as a finding.
+func secretFinding(m SecretMatch) Finding {
+	return Finding{
+		Title:       "Exposed " + m.Name,
+		Severity:    m.Severity,
+		Confidence:  m.Confidence,
+		Evidence:    fmt.Sprintf("line %d, offset %d: %s", m.Line, m.Offset, m.Snippet),
+		Location:    m.Location,
+		RuleID:      "secret-" + m.RuleID,
+		Remediation: "Revoke and rotate the credential, then remove it from the response or script.",
+		References:  []string{"https://cwe.mitre.org/data/definitions/798.html"},
+	}
+}
+
+// reportSecrets records the secrets found on a subdomain as findings, with a
+// tag per kind of secret.
+func reportSecrets(result *AnalysisResult, matches []SecretMatch) {
+tags:
+	for _, m := range matches {
+		result.addFinding(secretFinding(m))
+		name := "Secret: " + m.Name
+		for _, t := range result.Tags {
+			if t.Name == name {
+				continue tags
+			}
+		}
+		result.Tags = append(result.Tags, Tag{Name: name, Type: "secret"})
+	}
+}
+
+func writeSecretMatch(b *strings.Builder, indent string, m SecretMatch) {
+	fmt.Fprintf(b, "%s[%s] %s %s (%s:%d, offset %d, %s confidence): %s\n", indent, m.Severity, m.Name, m.Value, m.Location, m.Line, m.Offset, m.Confidence, m.Snippet)
+}
@@ -0,0 +1,130 @@
+package modules
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// scanSession holds the resources shared by every worker of a job so that
+// all of its outgoing traffic (HTTP probes, crawl fetches, port dials) is
+// paced by a single limiter.
+type scanSession struct {
+	limiter     *scanLimiter
+	transport   *http.Transport
+	client      *http.Client
+	dialer      *net.Dialer
+	dns         *dnsResolver          // set by subdomain jobs
+	takeover    []TakeoverFingerprint // set by subdomain jobs
+	crawl       crawlOptions          // set by subdomain jobs
+	ports       []int                 // set by subdomain jobs
+	portWorkers int                   // ports dialled at once per host IP
+	udp         bool                  // port scans include the UDP probes
+	secrets     *secretScanner
+
+	portSlotsMu sync.Mutex
+	portSlots   map[string]chan struct{} // IP -> one token per open port connection
+}
+
+// maxPageBody caps the response bodies read by the subdomain and URL probes.
+const maxPageBody = 5 << 20
+
+func newScanSession(rps, perHostRPS float64) *scanSession {
+	limiter := newScanLimiter(rps, perHostRPS)
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: cfg.Scan.HTTPTimeout}).DialContext,
+		TLSHandshakeTimeout:   cfg.Scan.HTTPTimeout,
+		ResponseHeaderTimeout: cfg.Scan.HTTPTimeout,
+		IdleConnTimeout:       30 * time.Second,
+		MaxIdleConnsPerHost:   2,
+	}
+	return &scanSession{
+		limiter:   limiter,
+		transport: transport,
+		// No Client.Timeout: time spent waiting for the limiter must not
+		// count against the request. limitedTransport starts the deadline,
+		// covering the body too, once the limiter lets the request through.
+		client:    &http.Client{Transport: &limitedTransport{base: transport, limiter: limiter, timeout: cfg.Scan.HTTPTimeout}},
+		dialer:    &net.Dialer{Timeout: cfg.Scan.DialTimeout},
+		secrets:   newSecretScanner(),
+		portSlots: make(map[string]chan struct{}),
+	}
+}
+
+// dial opens a rate-limited connection to host:port.
+func (s *scanSession) dial(ctx context.Context, network, host string, port int) (net.Conn, error) {
+	if err := s.limiter.Wait(ctx, host); err != nil {
+		return nil, err
+	}
+	return s.dialer.DialContext(ctx, network, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// acquirePortSlot waits until the job holds fewer than portWorkers port
+// connections to the IP of host, keyed like the per-host rate limit, and
+// returns the function that frees the slot.
+func (s *scanSession) acquirePortSlot(ctx context.Context, host string) (func(), error) {
+	key := s.limiter.hostKey(ctx, host)
+	s.portSlotsMu.Lock()
+	slots, ok := s.portSlots[key]
+	if !ok {
+		slots = make(chan struct{}, max(s.portWorkers, 1))
+		s.portSlots[key] = slots
+	}
+	s.portSlotsMu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// setDNS installs the job's DNS stage. Its resolver is then used for every
+// lookup of the job, including those of the per-host limiter.
+func (s *scanSession) setDNS(dns *dnsResolver) {
+	s.dns = dns
+	s.limiter.setResolver(dns.resolver)
+	s.dialer.Resolver = dns.resolver
+	s.transport.DialContext = (&net.Dialer{Timeout: cfg.Scan.HTTPTimeout, Resolver: dns.resolver}).DialContext
+}
+
+// resolver returns the resolver of the job's DNS stage, so that lookups
+// outside it also use the configured servers.
+func (s *scanSession) resolver() *net.Resolver {
+	if s.dns != nil {
+		return s.dns.resolver
+	}
+	return net.DefaultResolver
+}
+
+func (s *scanSession) close() {
+	s.transport.CloseIdleConnections()
+}
+
+func parsePositiveInt(value string, def int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+func parseNonNegativeInt(value string, def int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+func parsePositiveFloat(value string, def float64) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 {
+		return def
+	}
+	return f
+}
@@ -0,0 +1,405 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job kinds
+const (
+	JobKindSubdomain = "subdomain"
+	JobKindURL       = "url"
+)
+
+// Job statuses
+const (
+	JobStatusRunning     = "running"
+	JobStatusPaused      = "paused"
+	JobStatusCompleted   = "completed"
+	JobStatusCancelled   = "cancelled"
+	JobStatusTimedOut    = "timed_out"
+	JobStatusInterrupted = "interrupted"
+)
+
+var (
+	ErrJobNotFound      = errors.New("job not found")
+	ErrScheduleNotFound = errors.New("schedule not found")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrSessionNotFound  = errors.New("session not found")
+	ErrUserExists       = errors.New("user already exists")
+)
+
+// Job is the persisted description of an analysis run. Results are stored
+// separately so that listing jobs stays cheap.
+type Job struct {
+	ID         string            `json:"id"`
+	Kind       string            `json:"kind"`
+	Status     string            `json:"status"`
+	Options    map[string]string `json:"options,omitempty"`
+	Targets    []string          `json:"targets,omitempty"`
+	Total      int               `json:"total"`
+	Processed  int               `json:"processed"`
+	Owner      string            `json:"owner,omitempty"`    // username of the creator
+	EventSeq   int               `json:"eventSeq,omitempty"` // seq of the final event, see summaryEvents
+	CreatedAt  time.Time         `json:"createdAt"`
+	FinishedAt *time.Time        `json:"finishedAt,omitempty"`
+}
+
+// Store persists jobs, their results, scan schedules, accounts and sessions. Results are kept
+// as JSON so the same store can hold subdomain and URL analysis output.
+type Store interface {
+	SaveJob(job Job) error
+	GetJob(jobID string) (Job, error)
+	UpdateJob(jobID string, fn func(job *Job)) error // applies fn and saves in one transaction
+	ListJobs() ([]Job, error)
+	DeleteJob(jobID string) error
+	SaveResults(jobID string, results interface{}) error
+	LoadResults(jobID string, out interface{}) error
+	SaveSchedule(s Schedule) error
+	GetSchedule(id string) (Schedule, error)
+	ListSchedules() ([]Schedule, error)
+	DeleteSchedule(id string) error
+	SaveUser(u User) error
+	CreateUser(u User) error // fails with ErrUserExists instead of replacing
+	GetUser(username string) (User, error)
+	ListUsers() ([]User, error)
+	DeleteUser(username string) error
+	SaveSession(s Session) error
+	GetSession(id string) (Session, error)
+	DeleteSession(id string) error
+	DeleteUserSessions(username, keepID string) error // every session of the user but keepID
+	Close() error
+}
+
+var (
+	store   Store = NewMemoryStore()
+	storeMu sync.RWMutex
+)
+
+// SetStore replaces the active job store. It should be called once at
+// startup before any job is started.
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+func getStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+func createJob(kind, owner string, targets []string, options map[string]string) Job {
+	job := Job{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		Owner:     owner,
+		Status:    JobStatusRunning,
+		Options:   options,
+		Targets:   targets,
+		Total:     len(targets),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := getStore().SaveJob(job); err != nil {
+		log.Printf("Error saving job %s: %v", job.ID, err)
+	}
+	return job
+}
+
+// updateJob applies fn to the stored job and saves it back. Concurrent
+// updates of one job are serialized by the store, so none is lost.
+func updateJob(jobID string, fn func(job *Job)) {
+	if err := getStore().UpdateJob(jobID, fn); err != nil {
+		log.Printf("Error updating job %s: %v", jobID, err)
+	}
+}
+
+func finishJob(jobID string, status string, processed int) {
+	updateJob(jobID, func(job *Job) {
+		now := time.Now().UTC()
+		job.Status = status
+		job.Processed = processed
+		job.FinishedAt = &now
+	})
+}
+
+func StoreSubdomainResults(jobID string, results []AnalysisResult) {
+	if err := getStore().SaveResults(jobID, results); err != nil {
+		log.Printf("Error storing results for job %s: %v", jobID, err)
+	}
+}
+
+func GetSubdomainResults(jobID string) ([]AnalysisResult, bool) {
+	var results []AnalysisResult
+	if err := getStore().LoadResults(jobID, &results); err != nil {
+		if !errors.Is(err, ErrJobNotFound) {
+			log.Printf("Error loading results for job %s: %v", jobID, err)
+		}
+		return nil, false
+	}
+	return results, true
+}
+
+func StoreURLResults(jobID string, results []URLAnalysisResult) {
+	if err := getStore().SaveResults(jobID, results); err != nil {
+		log.Printf("Error storing results for job %s: %v", jobID, err)
+	}
+}
+
+func GetURLResults(jobID string) ([]URLAnalysisResult, bool) {
+	var results []URLAnalysisResult
+	if err := getStore().LoadResults(jobID, &results); err != nil {
+		if !errors.Is(err, ErrJobNotFound) {
+			log.Printf("Error loading results for job %s: %v", jobID, err)
+		}
+		return nil, false
+	}
+	return results, true
+}
+
+// MemoryStore keeps jobs in process memory. It is used when no on-disk
+// store has been configured.
+type MemoryStore struct {
+	mu        sync.Mutex
+	jobs      map[string]Job
+	results   map[string][]byte
+	schedules map[string]Schedule
+	users     map[string]User
+	sessions  map[string]Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs:      make(map[string]Job),
+		results:   make(map[string][]byte),
+		schedules: make(map[string]Schedule),
+		users:     make(map[string]User),
+		sessions:  make(map[string]Session),
+	}
+}
+
+func (m *MemoryStore) SaveJob(job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+func (m *MemoryStore) UpdateJob(jobID string, fn func(job *Job)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	fn(&job)
+	m.jobs[jobID] = job
+	return nil
+}
+
+func (m *MemoryStore) GetJob(jobID string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+func (m *MemoryStore) ListJobs() ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+func (m *MemoryStore) DeleteJob(jobID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[jobID]; !ok {
+		return ErrJobNotFound
+	}
+	delete(m.jobs, jobID)
+	delete(m.results, jobID)
+	return nil
+}
+
+func (m *MemoryStore) SaveResults(jobID string, results interface{}) error {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[jobID] = data
+	return nil
+}
+
+func (m *MemoryStore) LoadResults(jobID string, out interface{}) error {
+	m.mu.Lock()
+	data, ok := m.results[jobID]
+	m.mu.Unlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (m *MemoryStore) SaveSchedule(s Schedule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schedules[s.ID] = s
+	return nil
+}
+
+func (m *MemoryStore) GetSchedule(id string) (Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.schedules[id]
+	if !ok {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	return s, nil
+}
+
+func (m *MemoryStore) ListSchedules() ([]Schedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	schedules := make([]Schedule, 0, len(m.schedules))
+	for _, s := range m.schedules {
+		schedules = append(schedules, s)
+	}
+	sortSchedules(schedules)
+	return schedules, nil
+}
+
+func (m *MemoryStore) DeleteSchedule(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	delete(m.schedules, id)
+	return nil
+}
+
+func (m *MemoryStore) SaveUser(u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[u.Username] = u
+	return nil
+}
+
+func (m *MemoryStore) CreateUser(u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[u.Username]; ok {
+		return ErrUserExists
+	}
+	m.users[u.Username] = u
+	return nil
+}
+
+func (m *MemoryStore) GetUser(username string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[username]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (m *MemoryStore) ListUsers() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	sortUsers(users)
+	return users, nil
+}
+
+func (m *MemoryStore) DeleteUser(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; !ok {
+		return ErrUserNotFound
+	}
+	delete(m.users, username)
+	return nil
+}
+
+func (m *MemoryStore) SaveSession(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *MemoryStore) GetSession(id string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+func (m *MemoryStore) DeleteSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteUserSessions(username, keepID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if s.Username == username && id != keepID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Close() error { return nil }
+
+// sortJobs orders jobs newest first.
+func sortJobs(jobs []Job) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+}
+
+// sortUsers orders users by name.
+func sortUsers(users []User) {
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+}
+
+// sortSchedules orders schedules oldest first.
+func sortSchedules(schedules []Schedule) {
+	sort.SliceStable(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+}
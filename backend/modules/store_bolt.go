@@ -0,0 +1,362 @@
+package modules
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket      = []byte("jobs")
+	resultsBucket   = []byte("results")
+	schedulesBucket = []byte("schedules")
+	usersBucket     = []byte("users")
+	sessionsBucket  = []byte("sessions")
+)
+
+// BoltStore persists jobs, results, schedules, users and sessions in a
+// single BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens (or creates) the database at path. Jobs that were still
+// running or paused when the previous process exited are marked as
+// interrupted, and expired sessions are removed.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, resultsBucket, schedulesBucket, usersBucket, sessionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		jobs := tx.Bucket(jobsBucket)
+		stale := make(map[string][]byte)
+		err := jobs.ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if job.Status != JobStatusRunning && job.Status != JobStatusPaused {
+				return nil
+			}
+			job.Status = JobStatusInterrupted
+			data, err := json.Marshal(job)
+			if err != nil {
+				return err
+			}
+			stale[string(k)] = data
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, data := range stale {
+			if err := jobs.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+
+		sessions := tx.Bucket(sessionsBucket)
+		var expired [][]byte
+		now := time.Now()
+		err = sessions.ForEach(func(k, v []byte) error {
+			var s Session
+			if err := json.Unmarshal(v, &s); err != nil || now.After(s.ExpiresAt) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := sessions.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (b *BoltStore) SaveJob(job Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (b *BoltStore) UpdateJob(jobID string, fn func(job *Job)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobsBucket)
+		data := jobs.Get([]byte(jobID))
+		if data == nil {
+			return ErrJobNotFound
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			return err
+		}
+		fn(&job)
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return jobs.Put([]byte(jobID), data)
+	})
+}
+
+func (b *BoltStore) GetJob(jobID string) (Job, error) {
+	var job Job
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(jobID))
+		if data == nil {
+			return ErrJobNotFound
+		}
+		return json.Unmarshal(data, &job)
+	})
+	return job, err
+}
+
+func (b *BoltStore) ListJobs() ([]Job, error) {
+	jobs := []Job{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+func (b *BoltStore) DeleteJob(jobID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket(jobsBucket)
+		if jobs.Get([]byte(jobID)) == nil {
+			return ErrJobNotFound
+		}
+		if err := jobs.Delete([]byte(jobID)); err != nil {
+			return err
+		}
+		return tx.Bucket(resultsBucket).Delete([]byte(jobID))
+	})
+}
+
+func (b *BoltStore) SaveResults(jobID string, results interface{}) error {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).Put([]byte(jobID), data)
+	})
+}
+
+func (b *BoltStore) LoadResults(jobID string, out interface{}) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(resultsBucket).Get([]byte(jobID))
+		if data == nil {
+			return ErrJobNotFound
+		}
+		return json.Unmarshal(data, out)
+	})
+}
+
+func (b *BoltStore) SaveSchedule(s Schedule) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).Put([]byte(s.ID), data)
+	})
+}
+
+func (b *BoltStore) GetSchedule(id string) (Schedule, error) {
+	var s Schedule
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(schedulesBucket).Get([]byte(id))
+		if data == nil {
+			return ErrScheduleNotFound
+		}
+		return json.Unmarshal(data, &s)
+	})
+	return s, err
+}
+
+func (b *BoltStore) ListSchedules() ([]Schedule, error) {
+	schedules := []Schedule{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).ForEach(func(k, v []byte) error {
+			var s Schedule
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			schedules = append(schedules, s)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortSchedules(schedules)
+	return schedules, nil
+}
+
+func (b *BoltStore) DeleteSchedule(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		schedules := tx.Bucket(schedulesBucket)
+		if schedules.Get([]byte(id)) == nil {
+			return ErrScheduleNotFound
+		}
+		return schedules.Delete([]byte(id))
+	})
+}
+
+func (b *BoltStore) SaveUser(u User) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).Put([]byte(u.Username), data)
+	})
+}
+
+func (b *BoltStore) CreateUser(u User) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		if users.Get([]byte(u.Username)) != nil {
+			return ErrUserExists
+		}
+		return users.Put([]byte(u.Username), data)
+	})
+}
+
+func (b *BoltStore) GetUser(username string) (User, error) {
+	var u User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(usersBucket).Get([]byte(username))
+		if data == nil {
+			return ErrUserNotFound
+		}
+		return json.Unmarshal(data, &u)
+	})
+	return u, err
+}
+
+func (b *BoltStore) ListUsers() ([]User, error) {
+	users := []User{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+			var u User
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			users = append(users, u)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortUsers(users)
+	return users, nil
+}
+
+func (b *BoltStore) DeleteUser(username string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(usersBucket)
+		if users.Get([]byte(username)) == nil {
+			return ErrUserNotFound
+		}
+		return users.Delete([]byte(username))
+	})
+}
+
+func (b *BoltStore) SaveSession(s Session) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(s.ID), data)
+	})
+}
+
+func (b *BoltStore) GetSession(id string) (Session, error) {
+	var s Session
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if data == nil {
+			return ErrSessionNotFound
+		}
+		return json.Unmarshal(data, &s)
+	})
+	return s, err
+}
+
+func (b *BoltStore) DeleteSession(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		if sessions.Get([]byte(id)) == nil {
+			return ErrSessionNotFound
+		}
+		return sessions.Delete([]byte(id))
+	})
+}
+
+func (b *BoltStore) DeleteUserSessions(username, keepID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(sessionsBucket)
+		var ids [][]byte
+		err := sessions.ForEach(func(k, v []byte) error {
+			var s Session
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.Username == username && string(k) != keepID {
+				ids = append(ids, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := sessions.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,55 @@
+package modules
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestUpdateJobConcurrent(t *testing.T) {
+	bolt, err := OpenBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "bolt": bolt} {
+		SetStore(store)
+		job := createJob(JobKindSubdomain, "", nil, nil)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				updateJob(job.ID, func(job *Job) {
+					job.Targets = append(job.Targets, "host")
+					job.EventSeq++
+				})
+			}()
+		}
+		wg.Wait()
+		got, _ := store.GetJob(job.ID)
+		if len(got.Targets) != 50 || got.EventSeq != 50 {
+			t.Errorf("%s: %d targets, seq %d after 50 updates", name, len(got.Targets), got.EventSeq)
+		}
+	}
+}
+
+func TestSetJobStateAfterFinish(t *testing.T) {
+	SetStore(NewMemoryStore())
+	job := createJob(JobKindURL, "", []string{"https://example.com"}, nil)
+	startJobRuntime(job.ID, 0)
+	defer finishJobRuntime(job.ID)
+
+	if !setJobState(job.ID, JobStatusPaused) {
+		t.Fatal("pausing a running job failed")
+	}
+	setJobState(job.ID, JobStatusRunning)
+	finishJob(job.ID, JobStatusCompleted, 1)
+	if setJobState(job.ID, JobStatusPaused) {
+		t.Error("paused a job that had finished")
+	}
+	if got, _ := getStore().GetJob(job.ID); got.Status != JobStatusCompleted {
+		t.Errorf("status = %s, want %s", got.Status, JobStatusCompleted)
+	}
+}
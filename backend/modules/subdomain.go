@@ -2,21 +2,19 @@ package modules
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	wappalyzer "github.com/projectdiscovery/wappalyzergo"
 )
 
@@ -25,30 +23,57 @@ type Tag struct {
 	Type string `json:"Type"`
 }
 type AnalysisResult struct {
-	Subdomain     string   `json:"Subdomain"`
-	IsReachable   bool     `json:"IsReachable"`
-	StatusCode    int      `json:"StatusCode"`
-	ContentLength int64    `json:"ContentLength"`
-	Priority      string   `json:"Priority"`
-	Tags          []Tag    `json:"Tags"`
-	Endpoints     []string `json:"Endpoints"`
-	Headers       string   `json:"Headers"`
-	Technologies  []string `json:"Technologies"`
-	Report        string   `json:"Report"`
-	RequestInfo   string   `json:"-"` // Don't send to frontend JSON, only for report
-	FullResponse  string   `json:"-"`
+	Subdomain       string             `json:"Subdomain"`
+	IsReachable     bool               `json:"IsReachable"`
+	StatusCode      int                `json:"StatusCode"`
+	ContentLength   int64              `json:"ContentLength"`
+	Priority        string             `json:"Priority"`
+	Tags            []Tag              `json:"Tags"`
+	Endpoints       []Endpoint         `json:"Endpoints"`
+	Headers         string             `json:"Headers"`
+	ResponseHeaders http.Header        `json:"ResponseHeaders"`
+	Technologies    []string           `json:"Technologies"`
+	DNS             *DNSRecords        `json:"DNS"`      // nil for IP targets
+	Takeover        *TakeoverResult    `json:"Takeover"` // set when the subdomain can likely be claimed
+	TLS             *TLSInfo           `json:"TLS"`      // nil when nothing answered TLS
+	Ports           []PortResult       `json:"Ports"`    // port scan: open ports with their services
+	Findings        []Finding          `json:"Findings"` // most severe first
+	Scripts         []JSAnalysisResult `json:"Scripts"`  // deep crawl: scripts that were analysed
+	Report          string             `json:"Report"`
+	RequestInfo     string             `json:"-"` // Don't send to frontend JSON, only for report
+	FullResponse    string             `json:"-"`
 }
 type SubdomainAnalysisRequest struct {
 	Subdomains        []string `form:"subdomains[]"`
 	IsDeepCrawl       string   `form:"isDeepCrawl"`
 	IsPortScan        string   `form:"isPortScan"`
+	IsUDPScan         string   `form:"isUDPScan"` // port scan: also probe common UDP services
 	AIProvider        string   `form:"aiProvider"`
 	APIKey            string   `form:"apiKey"`
-	RequestsPerSecond string   `form:"requestsPerSecond"`
+	RequestsPerSecond string   `form:"requestsPerSecond"` // rate across all outgoing requests of the job
+	Concurrency       string   `form:"concurrency"`       // subdomains analysed in parallel
+	PerHostRPS        string   `form:"perHostRps"`        // optional rate per target IP
+	Timeout           string   `form:"timeout"`           // optional job deadline in seconds
+	Profile           string   `form:"profile"`           // named scan profile from the server config
+	RootDomain        string   `form:"rootDomain"`        // enumerate subdomains of this domain before analysis
+	EnumSources       []string `form:"enumSources[]"`     // ct, zone, bruteforce, history
+	Resolvers         string   `form:"resolvers"`         // comma-separated DNS servers for enumeration and resolution
+	CrawlDepth        string   `form:"crawlDepth"`        // deep crawl: links followed from the landing page
+	CrawlPages        string   `form:"crawlPages"`        // deep crawl: pages fetched per subdomain
+	CrawlScope        string   `form:"crawlScope"`        // deep crawl: host or domain
+	CrawlAllow        []string `form:"crawlAllow[]"`      // deep crawl: regular expressions crawled URLs must match
+	CrawlDeny         []string `form:"crawlDeny[]"`       // deep crawl: regular expressions excluded from the crawl
+	Ports             string   `form:"ports"`             // port scan: profiles, ports and ranges, e.g. top-1000 or 1-1024,8000-9000
+	PortConcurrency   string   `form:"portConcurrency"`   // port scan: ports dialled at once per host IP
+	Notify            string   `form:"notify"`            // JSON list of webhook, slack, discord or email sinks for this job
+
+	// Enumerators overrides the sources built from EnumSources, e.g. to use
+	// offline fixtures or uploaded wordlists and zone files.
+	Enumerators []EnumSource `form:"-"`
+	// NotifySinks is parsed from Notify by validate, or set by schedules.
+	NotifySinks []NotifierConfig `form:"-"`
 }
 
-var topPorts = []int{21, 22, 23, 25, 53, 80, 110, 111, 135, 139, 143, 443, 445, 993, 995, 1723, 3306, 3389, 5900, 8080, 8443}
-
 func HandleSubdomainAnalysis(c *gin.Context) {
 	var req SubdomainAnalysisRequest
 	if err := c.ShouldBind(&req); err != nil {
@@ -73,71 +98,406 @@ func HandleSubdomainAnalysis(c *gin.Context) {
 		req.Subdomains = append(req.Subdomains, parseSubdomainsFromText(buf.String())...)
 	}
 
-	if len(req.Subdomains) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No subdomains provided"})
+	if len(req.Subdomains) == 0 && req.RootDomain == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No subdomains or root domain provided"})
+		return
+	}
+	if req.RootDomain != "" {
+		wordlist, err := formFileBytes(c, "wordlist")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read wordlist"})
+			return
+		}
+		zoneFile, err := formFileBytes(c, "zoneFile")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read zone file"})
+			return
+		}
+		req.Enumerators, err = BuildEnumSources(EnumOptions{
+			Sources:   req.EnumSources,
+			Resolvers: req.resolverList(),
+			Wordlist:  wordlist,
+			ZoneFile:  zoneFile,
+			User:      currentUser(c),
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	jobID := uuid.New().String()
-	go performSubdomainAnalysis(req, jobID)
-	c.JSON(http.StatusOK, gin.H{"jobID": jobID})
+	job := createJob(JobKindSubdomain, currentUser(c).Username, req.Subdomains, req.jobOptions())
+	ctx := startJobRuntime(job.ID, parseJobTimeout(req.Timeout))
+	go performSubdomainAnalysis(ctx, req, job.ID)
+	c.JSON(http.StatusOK, gin.H{"jobID": job.ID})
+}
+
+// validate applies the scan profile of req and checks the options that are
+// only parsed once the scan runs.
+func (req *SubdomainAnalysisRequest) validate() error {
+	if !applyScanProfile(req) {
+		return fmt.Errorf("unknown scan profile %q", req.Profile)
+	}
+	if _, err := newCrawlOptions(*req); err != nil {
+		return err
+	}
+	if _, err := req.portList(); err != nil {
+		return err
+	}
+	if req.PortConcurrency != "" {
+		if n, err := strconv.Atoi(req.PortConcurrency); err != nil || n < 1 || n > maxPortConcurrency {
+			return fmt.Errorf("portConcurrency must be between 1 and %d", maxPortConcurrency)
+		}
+	}
+	if req.Notify != "" {
+		sinks, err := parseJobSinks(req.Notify)
+		if err != nil {
+			return err
+		}
+		req.NotifySinks = append(req.NotifySinks, sinks...)
+	}
+	return nil
+}
+
+// optionFields maps the form fields of req that hold a single value to the
+// fields themselves.
+func (req *SubdomainAnalysisRequest) optionFields() map[string]*string {
+	return map[string]*string{
+		"isDeepCrawl":       &req.IsDeepCrawl,
+		"isPortScan":        &req.IsPortScan,
+		"isUDPScan":         &req.IsUDPScan,
+		"aiProvider":        &req.AIProvider,
+		"requestsPerSecond": &req.RequestsPerSecond,
+		"concurrency":       &req.Concurrency,
+		"perHostRps":        &req.PerHostRPS,
+		"timeout":           &req.Timeout,
+		"profile":           &req.Profile,
+		"rootDomain":        &req.RootDomain,
+		"resolvers":         &req.Resolvers,
+		"crawlDepth":        &req.CrawlDepth,
+		"crawlPages":        &req.CrawlPages,
+		"crawlScope":        &req.CrawlScope,
+		"ports":             &req.Ports,
+		"portConcurrency":   &req.PortConcurrency,
+	}
+}
+
+// jobOptions returns the options of req recorded with its job. The API key
+// is left out.
+func (req SubdomainAnalysisRequest) jobOptions() map[string]string {
+	opts := map[string]string{
+		"enumSources": strings.Join(req.EnumSources, ","),
+		"crawlAllow":  strings.Join(req.CrawlAllow, "\n"),
+		"crawlDeny":   strings.Join(req.CrawlDeny, "\n"),
+	}
+	for name, value := range req.optionFields() {
+		opts[name] = *value
+	}
+	return opts
+}
+
+// subdomainRequestFromOptions builds a request from options in the format of
+// jobOptions.
+func subdomainRequestFromOptions(opts map[string]string) (SubdomainAnalysisRequest, error) {
+	var req SubdomainAnalysisRequest
+	fields := req.optionFields()
+	for name, value := range opts {
+		switch name {
+		case "enumSources":
+			req.EnumSources = SplitList(value)
+		case "crawlAllow", "crawlDeny":
+			var patterns []string
+			for _, p := range strings.Split(value, "\n") {
+				if p != "" {
+					patterns = append(patterns, p)
+				}
+			}
+			if name == "crawlAllow" {
+				req.CrawlAllow = patterns
+			} else {
+				req.CrawlDeny = patterns
+			}
+		default:
+			field, ok := fields[name]
+			if !ok {
+				return req, fmt.Errorf("unknown option %q", name)
+			}
+			*field = value
+		}
+	}
+	return req, nil
+}
+
+func performSubdomainAnalysis(ctx context.Context, req SubdomainAnalysisRequest, jobID string) {
+	defer finishJobRuntime(jobID)
+
+	BroadcastJobStarted(jobID)
+	if req.RootDomain != "" {
+		BroadcastProgress(jobID, 0, fmt.Sprintf("Enumerating subdomains of %s", req.RootDomain))
+		req.Subdomains = enumerateTargets(ctx, req)
+		updateJob(jobID, func(job *Job) {
+			job.Targets = req.Subdomains
+			job.Total = len(req.Subdomains)
+		})
+	}
+
+	total := len(req.Subdomains)
+	finalResults := runSubdomainAnalysis(ctx, req, jobID, func(processed, targets int, message string) {
+		total = targets // grows as certificates reveal new subdomains
+		setJobProcessed(jobID, processed)
+	})
+	processed := len(finalResults)
+
+	StoreSubdomainResults(jobID, finalResults)
+	var findings []Finding
+	for _, r := range finalResults {
+		findings = append(findings, r.Findings...)
+	}
+	status := jobEndStatus(ctx)
+	defer notifyJobEnd(jobID, JobKindSubdomain, status, req.NotifySinks, findings)
+	if status != JobStatusCompleted {
+		finishJob(jobID, status, processed)
+		BroadcastJobEnd(jobID, percent(processed, total), status)
+		return
+	}
+	finishJob(jobID, JobStatusCompleted, processed)
+	BroadcastJobEnd(jobID, 100, JobStatusCompleted)
+}
+
+// RunSubdomainAnalysis scans req.Subdomains without registering a job, for
+// callers such as the command-line mode that consume the results directly.
+func RunSubdomainAnalysis(ctx context.Context, req SubdomainAnalysisRequest, progress ProgressFunc) ([]AnalysisResult, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	if req.RootDomain != "" {
+		req.Subdomains = enumerateTargets(ctx, req)
+	}
+	return runSubdomainAnalysis(ctx, req, "", progress), nil
+}
+
+// enumerateTargets merges the subdomains discovered under req.RootDomain into
+// the explicitly requested ones, keeping their order and dropping duplicates.
+func enumerateTargets(ctx context.Context, req SubdomainAnalysisRequest) []string {
+	sources := req.Enumerators
+	if sources == nil {
+		var err error
+		sources, err = BuildEnumSources(EnumOptions{Sources: req.EnumSources, Resolvers: req.resolverList()})
+		if err != nil {
+			log.Printf("Error building enumeration sources: %v", err)
+			return req.Subdomains
+		}
+	}
+	seen := make(map[string]bool)
+	var targets []string
+	for _, name := range append(req.Subdomains, EnumerateSubdomains(ctx, req.RootDomain, sources)...) {
+		if key := normalizeHostname(name); !seen[key] {
+			seen[key] = true
+			targets = append(targets, name)
+		}
+	}
+	return targets
+}
+
+// maxSANTargets bounds the subdomains a job adds from TLS certificates.
+const maxSANTargets = 1000
+
+// sanCandidates picks the certificate names worth scanning: new names in the
+// registrable domains of the job's targets. It is not safe for concurrent
+// use.
+type sanCandidates struct {
+	domains map[string]bool
+	seen    map[string]bool
+	added   int
+}
+
+func newSANCandidates(root string, targets []string) *sanCandidates {
+	c := &sanCandidates{domains: make(map[string]bool), seen: make(map[string]bool)}
+	if root != "" {
+		c.domains[registrableDomain(normalizeHostname(root))] = true
+	}
+	for _, t := range targets {
+		host := normalizeHostname(hostOnly(t))
+		c.seen[host] = true
+		if net.ParseIP(host) == nil {
+			c.domains[registrableDomain(host)] = true
+		}
+	}
+	return c
+}
+
+// add returns the names that have not been seen before and should be
+// scanned.
+func (c *sanCandidates) add(names []string) []string {
+	var added []string
+	for _, name := range names {
+		name = normalizeHostname(name)
+		if c.added >= maxSANTargets || name == "" || strings.Contains(name, "*") || net.ParseIP(name) != nil {
+			continue
+		}
+		if c.seen[name] || !c.domains[registrableDomain(name)] {
+			continue
+		}
+		c.seen[name] = true
+		c.added++
+		added = append(added, name)
+	}
+	return added
+}
+
+// portList returns the ports to scan, those of the configuration unless the
+// request selects some.
+func (req SubdomainAnalysisRequest) portList() ([]int, error) {
+	if req.Ports == "" {
+		return cfg.Scan.Ports, nil
+	}
+	return parsePorts(req.Ports)
+}
+
+func (req SubdomainAnalysisRequest) resolverList() []string {
+	if req.Resolvers != "" {
+		return SplitList(req.Resolvers)
+	}
+	return cfg.Scan.Resolvers
 }
 
-func performSubdomainAnalysis(req SubdomainAnalysisRequest, jobID string) {
+// runSubdomainAnalysis analyses every subdomain of req and returns the
+// results sorted likely takeovers first, then reachable ones by priority.
+// Names found in TLS certificates are scanned too, in further rounds, when
+// they belong to the registrable domain of a target. jobID may be empty when
+// the scan is not a pausable job.
+func runSubdomainAnalysis(ctx context.Context, req SubdomainAnalysisRequest, jobID string, progress ProgressFunc) []AnalysisResult {
 	total := len(req.Subdomains)
 	var processed int
 	var mu sync.Mutex
 	var finalResults []AnalysisResult
 	var wg sync.WaitGroup
-	rps, err := strconv.Atoi(req.RequestsPerSecond)
-	if err != nil || rps <= 0 {
-		rps = 10 // Default value
-	}
-	guard := make(chan struct{}, rps)
-
-	for _, subdomain := range req.Subdomains {
-		wg.Add(1)
-		guard <- struct{}{}
-		go func(sd string) {
-			defer wg.Done()
-			defer func() { <-guard }()
-			for GetJobState(jobID) == "paused" {
-				time.Sleep(500 * time.Millisecond)
+	cands := newSANCandidates(req.RootDomain, req.Subdomains)
+	sess := newScanSession(parsePositiveFloat(req.RequestsPerSecond, cfg.Scan.RequestsPerSecond), parsePositiveFloat(req.PerHostRPS, cfg.Scan.PerHostRPS))
+	defer sess.close()
+	sess.setDNS(newDNSResolver(req.resolverList()))
+	sess.takeover = takeoverFingerprints()
+	sess.crawl, _ = newCrawlOptions(req) // validated by the callers
+	sess.ports, _ = req.portList()
+	sess.portWorkers = parsePositiveInt(req.PortConcurrency, cfg.Scan.PortConcurrency)
+	sess.udp = req.IsUDPScan == "true"
+	guard := make(chan struct{}, parsePositiveInt(req.Concurrency, cfg.Scan.Concurrency))
+
+	round := req.Subdomains
+	for len(round) > 0 && ctx.Err() == nil {
+		var found []string
+	dispatch:
+		for _, subdomain := range round {
+			select {
+			case guard <- struct{}{}:
+			case <-ctx.Done():
+				break dispatch
 			}
-			result := analyzeSingleSubdomain(sd, req.IsDeepCrawl == "true", req.IsPortScan == "true")
-			mu.Lock()
-			processed++
-			finalResults = append(finalResults, result)
-			progress := (processed * 100) / total
-			BroadcastProgress(jobID, progress, fmt.Sprintf("Scanning %d/%d: %s", processed, total, sd))
-			mu.Unlock()
-		}(subdomain)
+			wg.Add(1)
+			go func(sd string) {
+				defer wg.Done()
+				defer func() { <-guard }()
+				if waitIfPaused(ctx, jobID) != nil {
+					return
+				}
+				mu.Lock()
+				started := percent(processed, total)
+				mu.Unlock()
+				BroadcastHostStarted(jobID, started, sd)
+				result := analyzeSingleSubdomain(ctx, sess, sd, req.IsDeepCrawl == "true", req.IsPortScan == "true")
+				if ctx.Err() != nil {
+					return // aborted mid-scan, the result is incomplete
+				}
+				mu.Lock()
+				if result.TLS != nil {
+					if names := cands.add(result.TLS.SANs); len(names) > 0 {
+						found = append(found, names...)
+						total += len(names)
+					}
+				}
+				processed++
+				finalResults = append(finalResults, result)
+				message := fmt.Sprintf("Scanning %d/%d: %s", processed, total, sd)
+				done := percent(processed, total)
+				progress(processed, total, message)
+				mu.Unlock()
+				BroadcastHostResult(jobID, done, message, sd, result, result.Findings)
+			}(subdomain)
+		}
+		wg.Wait()
+		if len(found) > 0 && jobID != "" {
+			updateJob(jobID, func(job *Job) {
+				job.Targets = append(job.Targets, found...)
+				job.Total = len(job.Targets)
+			})
+		}
+		round = found
 	}
-	wg.Wait()
 
 	sort.SliceStable(finalResults, func(i, j int) bool {
+		if (finalResults[i].Takeover != nil) != (finalResults[j].Takeover != nil) {
+			return finalResults[i].Takeover != nil
+		}
 		if finalResults[i].IsReachable != finalResults[j].IsReachable {
 			return finalResults[i].IsReachable
 		}
 		priorityOrder := map[string]int{"High": 0, "Medium": 1, "Low": 2}
 		return priorityOrder[finalResults[i].Priority] < priorityOrder[finalResults[j].Priority]
 	})
-	StoreSubdomainResults(jobID, finalResults)
-	BroadcastFinalResults(jobID, finalResults)
+	return finalResults
 }
 
-func analyzeSingleSubdomain(subdomain string, isDeepCrawl bool, isPortScan bool) AnalysisResult {
+func analyzeSingleSubdomain(ctx context.Context, sess *scanSession, subdomain string, isDeepCrawl bool, isPortScan bool) AnalysisResult {
 	result := AnalysisResult{Subdomain: subdomain, Priority: "Low"}
-	client := &http.Client{Timeout: 10 * time.Second}
+	var service *TakeoverFingerprint
+	var serviceCNAME string
+	if sess.dns != nil {
+		if host := hostOnly(subdomain); net.ParseIP(host) == nil {
+			dnsCtx, cancel := context.WithTimeout(ctx, cfg.Scan.HTTPTimeout)
+			result.DNS = sess.dns.Resolve(dnsCtx, host)
+			cancel()
+			service, serviceCNAME = matchTakeoverService(sess.takeover, result.DNS.CNAMEChain)
+			if service != nil {
+				if t := service.checkDangling(result.DNS, serviceCNAME); t != nil {
+					flagTakeover(&result, t)
+				}
+			}
+			// Names that do not exist cannot be probed, and wildcard matches
+			// would only show the zone's catch-all host.
+			switch {
+			case result.DNS.NXDomain:
+				result.Tags = append(result.Tags, Tag{Name: "DNS: NXDOMAIN", Type: "dns"})
+				result.Report = generateReport(result, isDeepCrawl, isPortScan)
+				return result
+			case result.DNS.Wildcard:
+				result.Tags = append(result.Tags, Tag{Name: "DNS: Wildcard", Type: "dns"})
+				result.Report = generateReport(result, isDeepCrawl, isPortScan)
+				return result
+			}
+		}
+	}
+	tlsHost, tlsPort := hostOnly(subdomain), 443
+	if _, p, err := net.SplitHostPort(subdomain); err == nil {
+		tlsPort, _ = strconv.Atoi(p)
+	}
+	if result.TLS = inspectTLS(ctx, sess, tlsHost, tlsPort); result.TLS != nil {
+		reportTLS(&result, result.TLS, net.JoinHostPort(tlsHost, strconv.Itoa(tlsPort)))
+	}
+
+	client := sess.client
 	var req *http.Request
 	var resp *http.Response
 	var err error
 	httpsURL := "https://" + subdomain
-	req, _ = http.NewRequest("GET", httpsURL, nil)
+	req, _ = http.NewRequestWithContext(ctx, "GET", httpsURL, nil)
 	resp, err = client.Do(req)
-	if err != nil {
+	if err != nil && ctx.Err() == nil {
 		httpURL := "http://" + subdomain
-		req, _ = http.NewRequest("GET", httpURL, nil)
+		req, _ = http.NewRequestWithContext(ctx, "GET", httpURL, nil)
 		resp, err = client.Do(req)
 	}
 
@@ -156,13 +516,20 @@ func analyzeSingleSubdomain(subdomain string, isDeepCrawl bool, isPortScan bool)
 	result.IsReachable = true
 	result.StatusCode = resp.StatusCode
 	result.ContentLength = resp.ContentLength
+	result.ResponseHeaders = resp.Header
+	for _, f := range auditHeaders(ctx, sess, resp) {
+		result.addFinding(f)
+	}
 
-	highPriorityKeywords := []string{"admin", "login", "portal", "dashboard", "api", "payment", "vpn", "remote", "cpanel", "ssh"}
-	mediumPriorityKeywords := []string{"dev", "staging", "test", "uat", "demo", "git", "jira", "ci", "cd"}
-	if containsAny(subdomain, highPriorityKeywords) {
-		result.Priority = "High"
-	} else if containsAny(subdomain, mediumPriorityKeywords) {
-		result.Priority = "Medium"
+	if f, ok := sensitiveHostnameFinding(subdomain); ok {
+		result.addFinding(f)
+	}
+	if service != nil && result.Takeover == nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxPageBody))
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if t := service.checkBody(body, serviceCNAME); t != nil {
+			flagTakeover(&result, t)
+		}
 	}
 
 	// If both deepcrawl and portscan are false, return minimal info
@@ -172,7 +539,7 @@ func analyzeSingleSubdomain(subdomain string, isDeepCrawl bool, isPortScan bool)
 	}
 
 	// Read body for further analysis
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBody))
 	if err != nil {
 		// Handle error if body can't be read, but proceed
 	}
@@ -201,31 +568,16 @@ func analyzeSingleSubdomain(subdomain string, isDeepCrawl bool, isPortScan bool)
 			}
 		}
 
-		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(bodyBytes))
-		if err == nil {
-			endpointSet := make(map[string]bool)
-			doc.Find("a[href], script[src]").Each(func(i int, s *goquery.Selection) {
-				var path string
-				if href, exists := s.Attr("href"); exists {
-					path = href
-				} else if src, exists := s.Attr("src"); exists {
-					path = src
-				}
-				if isInterestingEndpoint(path) {
-					endpointSet[toAbsoluteURL(resp.Request.URL, path)] = true
-				}
-			})
-			for ep := range endpointSet {
-				result.Endpoints = append(result.Endpoints, ep)
-			}
-			sort.Strings(result.Endpoints)
-		}
+		var secrets []SecretMatch
+		result.Endpoints, secrets = crawlSite(ctx, sess, sess.crawl, resp.Request.URL, bodyBytes)
+		reportSecrets(&result, secrets)
+		analyzeCrawledScripts(ctx, sess, &result, resp.Request.URL)
 	}
 
-	// If portscan is true, add port scan results
 	if isPortScan {
-		for _, port := range scanPorts(subdomain) {
-			result.Tags = append(result.Tags, Tag{Name: fmt.Sprintf("Port: %d", port), Type: "port"})
+		result.Ports = scanPorts(ctx, sess, subdomain)
+		if sess.udp {
+			result.Ports = append(result.Ports, scanUDPPorts(ctx, sess, subdomain)...)
 		}
 	}
 
@@ -240,13 +592,29 @@ func generateReport(result AnalysisResult, isDeepCrawl, isPortScan bool) string
 	fmt.Fprintf(&b, "Reachable: %v\n", result.IsReachable)
 	fmt.Fprintf(&b, "Priority: %s\n", result.Priority)
 
+	if result.IsReachable {
+		fmt.Fprintf(&b, "Status: %d\n", result.StatusCode)
+		fmt.Fprintf(&b, "Content-Length: %d\n", result.ContentLength)
+	}
+	if result.DNS != nil {
+		writeDNSReport(&b, result.DNS)
+	}
+	if t := result.Takeover; t != nil {
+		b.WriteString("\nPossible Subdomain Takeover:\n")
+		fmt.Fprintf(&b, "- Service: %s\n", t.Service)
+		fmt.Fprintf(&b, "- CNAME: %s\n", t.CNAME)
+		fmt.Fprintf(&b, "- Evidence: %s\n", t.Evidence)
+	}
+	if result.TLS != nil {
+		writeTLSReport(&b, result.TLS)
+	}
+	if len(result.Findings) > 0 {
+		writeFindings(&b, result.Findings)
+	}
 	if !result.IsReachable {
 		return b.String()
 	}
 
-	fmt.Fprintf(&b, "Status: %d\n", result.StatusCode)
-	fmt.Fprintf(&b, "Content-Length: %d\n", result.ContentLength)
-
 	if isDeepCrawl {
 		if len(result.Technologies) > 0 {
 			b.WriteString("\nTechnologies Detected:\n")
@@ -257,24 +625,23 @@ func generateReport(result AnalysisResult, isDeepCrawl, isPortScan bool) string
 		if len(result.Endpoints) > 0 {
 			b.WriteString("\nDiscovered Endpoints:\n")
 			for _, ep := range result.Endpoints {
-				fmt.Fprintf(&b, "- %s\n", ep)
+				fmt.Fprintf(&b, "- %s %s (depth %d, from %s)", ep.Method, ep.URL, ep.Depth, ep.Source)
+				if len(ep.Params) > 0 {
+					fmt.Fprintf(&b, " params: %s", strings.Join(ep.Params, ", "))
+				}
+				b.WriteString("\n")
 			}
 		}
+		if len(result.Scripts) > 0 {
+			writeScriptReport(&b, result.Scripts)
+		}
 		if result.Headers != "" {
 			b.WriteString("\nHeaders:\n" + result.Headers)
 		}
 	}
 
-	if isPortScan {
-		ports := []string{}
-		for _, tag := range result.Tags {
-			if tag.Type == "port" {
-				ports = append(ports, strings.TrimPrefix(tag.Name, "Port: "))
-			}
-		}
-		if len(ports) > 0 {
-			b.WriteString("\nOpen Ports:\n" + strings.Join(ports, ", ") + "\n")
-		}
+	if isPortScan && len(result.Ports) > 0 {
+		writePortReport(&b, result.Ports)
 	}
 
 	if isDeepCrawl && result.RequestInfo != "" {
@@ -284,29 +651,59 @@ func generateReport(result AnalysisResult, isDeepCrawl, isPortScan bool) string
 	return b.String()
 }
 
-func scanPorts(subdomain string) []int {
-	var openPorts []int
-	var wg sync.WaitGroup
-	portsChan := make(chan int, len(topPorts))
-	for _, port := range topPorts {
-		wg.Add(1)
-		go func(p int) {
-			defer wg.Done()
-			if conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", subdomain, p), 1*time.Second); err == nil {
-				conn.Close()
-				portsChan <- p
-			}
-		}(port)
+func writeDNSReport(b *strings.Builder, rec *DNSRecords) {
+	b.WriteString("\nDNS Records:\n")
+	switch {
+	case rec.NXDomain:
+		b.WriteString("- Does not resolve (NXDOMAIN)\n")
+		return
+	case rec.Wildcard:
+		b.WriteString("- Matches the parent zone's wildcard record, not probed\n")
 	}
-	go func() {
-		wg.Wait()
-		close(portsChan)
-	}()
-	for port := range portsChan {
-		openPorts = append(openPorts, port)
+	list := func(name string, values []string) {
+		if len(values) > 0 {
+			fmt.Fprintf(b, "- %s: %s\n", name, strings.Join(values, ", "))
+		}
+	}
+	list("A", rec.A)
+	list("AAAA", rec.AAAA)
+	if len(rec.CNAMEChain) > 0 {
+		fmt.Fprintf(b, "- CNAME: %s\n", strings.Join(rec.CNAMEChain, " -> "))
+	}
+	if rec.Dangling {
+		b.WriteString("- Alias target does not resolve\n")
+	}
+	list("MX", rec.MX)
+	list("NS", rec.NS)
+	for _, txt := range rec.TXT {
+		fmt.Fprintf(b, "- TXT: %q\n", txt)
+	}
+	if rec.Error != "" {
+		fmt.Fprintf(b, "- Lookup error: %s\n", rec.Error)
+	}
+}
+
+// formFileBytes returns the content of an optional uploaded file, or nil if
+// the field is absent.
+func formFileBytes(c *gin.Context, field string) ([]byte, error) {
+	file, err := c.FormFile(field)
+	if err != nil {
+		return nil, nil
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
 	}
-	sort.Ints(openPorts)
-	return openPorts
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// hostOnly strips an optional port from a target.
+func hostOnly(target string) string {
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		return host
+	}
+	return target
 }
 
 func parseSubdomainsFromText(text string) []string {
@@ -318,14 +715,53 @@ func parseSubdomainsFromText(text string) []string {
 	}
 	return subdomains
 }
-func containsAny(s string, substrs []string) bool {
-	for _, substr := range substrs {
-		if strings.Contains(s, substr) {
-			return true
+
+// sensitiveHostnameFinding reports a hostname that names an administrative,
+// payment or pre-production service, which are more often exposed by
+// mistake than the rest.
+func sensitiveHostnameFinding(subdomain string) (Finding, bool) {
+	highPriorityKeywords := []string{"admin", "login", "portal", "dashboard", "api", "payment", "vpn", "remote", "cpanel", "ssh"}
+	mediumPriorityKeywords := []string{"dev", "staging", "test", "uat", "demo", "git", "jira", "ci", "cd"}
+	tokens := hostnameTokens(subdomain)
+	severity, keyword := SeverityHigh, firstToken(tokens, highPriorityKeywords)
+	if keyword == "" {
+		severity, keyword = SeverityMedium, firstToken(tokens, mediumPriorityKeywords)
+	}
+	if keyword == "" {
+		return Finding{}, false
+	}
+	return Finding{
+		Title:       "Sensitive hostname",
+		Severity:    severity,
+		Confidence:  ConfidenceLow,
+		Evidence:    fmt.Sprintf("hostname contains %q", keyword),
+		Location:    subdomain,
+		RuleID:      "sensitive-hostname",
+		Remediation: "Make sure the service is meant to be public; otherwise restrict it to a VPN or an IP allowlist.",
+	}, true
+}
+
+// hostnameTokens splits the labels of a hostname on "-" and "_", so that
+// "vpn-gw.dev.example.com" yields vpn, gw, dev, example and com.
+func hostnameTokens(subdomain string) []string {
+	return strings.FieldsFunc(strings.ToLower(hostOnly(subdomain)), func(r rune) bool {
+		return r == '.' || r == '-' || r == '_'
+	})
+}
+
+// firstToken returns the first of keywords that is a whole token, ignoring
+// trailing digits ("dev2" is dev, "cdn" is not cd), or "".
+func firstToken(tokens, keywords []string) string {
+	for _, keyword := range keywords {
+		for _, token := range tokens {
+			if strings.TrimRight(token, "0123456789") == keyword {
+				return keyword
+			}
 		}
 	}
-	return false
+	return ""
 }
+
 func isInterestingEndpoint(path string) bool {
 	path = strings.ToLower(path)
 	if strings.HasPrefix(path, "#") || strings.HasPrefix(path, "mailto:") {
@@ -333,10 +769,3 @@ func isInterestingEndpoint(path string) bool {
 	}
 	return true
 }
-func toAbsoluteURL(base *url.URL, path string) string {
-	rel, err := url.Parse(path)
-	if err != nil {
-		return path
-	}
-	return base.ResolveReference(rel).String()
-}
@@ -0,0 +1,44 @@
+package modules
+
+import "testing"
+
+func TestSensitiveHostnameFinding(t *testing.T) {
+	tests := []struct {
+		host     string
+		severity string // "" when the name is not sensitive
+		keyword  string
+	}{
+		{"admin.example.com", SeverityHigh, "admin"},
+		{"api-v2.example.com", SeverityHigh, "api"},
+		{"vpn2.example.com:8443", SeverityHigh, "vpn"},
+		{"shop.dev.example.com", SeverityMedium, "dev"},
+		{"ci_runner.example.com", SeverityMedium, "ci"},
+		{"staging-api.example.com", SeverityHigh, "api"},
+		{"socials.example.com", "", ""},
+		{"cdn.example.com", "", ""},
+		{"capital.example.com", "", ""},
+		{"devices.example.com", "", ""},
+		{"rapid.example.com", "", ""},
+		{"digital.example.com", "", ""},
+		{"www.example.com", "", ""},
+	}
+	for _, tt := range tests {
+		f, ok := sensitiveHostnameFinding(tt.host)
+		if ok != (tt.severity != "") {
+			t.Errorf("%s: flagged %v (%s)", tt.host, ok, f.Evidence)
+			continue
+		}
+		if ok && (f.Severity != tt.severity || f.Evidence != `hostname contains "`+tt.keyword+`"`) {
+			t.Errorf("%s: severity %s, evidence %s", tt.host, f.Severity, f.Evidence)
+		}
+	}
+}
+
+func TestValidatePortConcurrency(t *testing.T) {
+	for value, ok := range map[string]bool{"": true, "1": true, "500": true, "0": false, "501": false, "many": false} {
+		req := SubdomainAnalysisRequest{Subdomains: []string{"example.com"}, PortConcurrency: value}
+		if err := req.validate(); (err == nil) != ok {
+			t.Errorf("portConcurrency %q: err = %v, want ok %v", value, err, ok)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package modules
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// TakeoverFingerprint describes a hosted service whose unclaimed resources
+// can be registered by anyone. The bundled list follows the
+// can-i-take-over-xyz project and can be replaced through
+// scan.takeoverFingerprints in the config.
+type TakeoverFingerprint struct {
+	Service     string   `json:"service"`
+	CNAME       []string `json:"cname"`       // domain suffixes, or path.Match patterns when they contain "*"
+	Fingerprint []string `json:"fingerprint"` // response body snippets of an unclaimed resource
+	NXDomain    bool     `json:"nxdomain"`    // a CNAME target that does not resolve is claimable
+}
+
+// TakeoverResult is a likely subdomain takeover.
+type TakeoverResult struct {
+	Service  string `json:"Service"`
+	CNAME    string `json:"CNAME"`    // the alias that matched the service
+	Evidence string `json:"Evidence"` // "NXDOMAIN" or the matched response fingerprint
+}
+
+//go:embed data/takeover.json
+var defaultTakeoverFingerprints []byte
+
+// LoadTakeoverFingerprints reads a fingerprint file, or the bundled list if
+// path is empty.
+func LoadTakeoverFingerprints(path string) ([]TakeoverFingerprint, error) {
+	data := defaultTakeoverFingerprints
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var fps []TakeoverFingerprint
+	if err := json.Unmarshal(data, &fps); err != nil {
+		return nil, fmt.Errorf("parsing takeover fingerprints: %w", err)
+	}
+	for i, fp := range fps {
+		if fp.Service == "" || len(fp.CNAME) == 0 {
+			return nil, fmt.Errorf("takeover fingerprint %d: service and cname are required", i)
+		}
+		if len(fp.Fingerprint) == 0 && !fp.NXDomain {
+			return nil, fmt.Errorf("takeover fingerprint %q: needs a fingerprint or nxdomain", fp.Service)
+		}
+	}
+	return fps, nil
+}
+
+// takeoverFingerprints returns the configured fingerprints. The file is read
+// for every job so that it can be updated without restarting the server.
+func takeoverFingerprints() []TakeoverFingerprint {
+	fps, err := LoadTakeoverFingerprints(cfg.Scan.TakeoverFingerprints)
+	if err != nil {
+		log.Printf("Error loading takeover fingerprints, using the bundled list: %v", err)
+		fps, _ = LoadTakeoverFingerprints("")
+	}
+	return fps
+}
+
+// matchTakeoverService returns the service that any alias of chain points
+// at, and the alias that matched.
+func matchTakeoverService(fps []TakeoverFingerprint, chain []string) (*TakeoverFingerprint, string) {
+	for _, name := range chain {
+		for i := range fps {
+			for _, pattern := range fps[i].CNAME {
+				if matchCNAMEPattern(pattern, name) {
+					return &fps[i], name
+				}
+			}
+		}
+	}
+	return nil, ""
+}
+
+func matchCNAMEPattern(pattern, name string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.Contains(pattern, "*") {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	return inDomain(name, pattern)
+}
+
+// checkDangling reports a takeover when the alias chain ends in a name that
+// does not exist and the service hands out such names to anyone.
+func (fp *TakeoverFingerprint) checkDangling(rec *DNSRecords, cname string) *TakeoverResult {
+	if !fp.NXDomain || !rec.Dangling {
+		return nil
+	}
+	return &TakeoverResult{Service: fp.Service, CNAME: cname, Evidence: "NXDOMAIN"}
+}
+
+// checkBody reports a takeover when the response is the service's page for
+// an unclaimed resource.
+func (fp *TakeoverFingerprint) checkBody(body []byte, cname string) *TakeoverResult {
+	for _, s := range fp.Fingerprint {
+		if bytes.Contains(body, []byte(s)) {
+			return &TakeoverResult{Service: fp.Service, CNAME: cname, Evidence: s}
+		}
+	}
+	return nil
+}
+
+func flagTakeover(result *AnalysisResult, t *TakeoverResult) {
+	result.Takeover = t
+	result.Tags = append(result.Tags, Tag{Name: "Takeover: " + t.Service, Type: "takeover"})
+	evidence := fmt.Sprintf("CNAME %s does not resolve", t.CNAME)
+	if t.Evidence != "NXDOMAIN" {
+		evidence = fmt.Sprintf("CNAME %s, response contains %q", t.CNAME, t.Evidence)
+	}
+	result.addFinding(Finding{
+		Title:       "Possible subdomain takeover (" + t.Service + ")",
+		Severity:    SeverityHigh,
+		Confidence:  ConfidenceHigh,
+		Evidence:    evidence,
+		Location:    result.Subdomain,
+		RuleID:      "subdomain-takeover",
+		Remediation: "Remove the DNS record, or claim the resource at " + t.Service + " before someone else does.",
+		References:  []string{"https://github.com/EdOverflow/can-i-take-over-xyz"},
+	})
+}
@@ -0,0 +1,300 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// TLSInfo describes the certificate and configuration of a TLS endpoint.
+type TLSInfo struct {
+	Version          string     `json:"Version"` // negotiated with the default client settings
+	CipherSuite      string     `json:"CipherSuite"`
+	Subject          string     `json:"Subject"`
+	Issuer           string     `json:"Issuer"`
+	NotBefore        time.Time  `json:"NotBefore"`
+	NotAfter         time.Time  `json:"NotAfter"`
+	SANs             []string   `json:"SANs"`  // DNS names and IP addresses of the leaf certificate
+	Chain            []CertInfo `json:"Chain"` // as sent by the server, leaf first
+	Trusted          bool       `json:"Trusted"`
+	SelfSigned       bool       `json:"SelfSigned"`
+	Expired          bool       `json:"Expired"`
+	HostnameMismatch bool       `json:"HostnameMismatch"`
+	VerifyError      string     `json:"VerifyError"`
+	Versions         []string   `json:"Versions"`    // protocol versions the server accepts
+	WeakCiphers      []string   `json:"WeakCiphers"` // accepted suites that are broken or lack forward secrecy
+	Error            string     `json:"Error"`
+}
+
+// CertInfo is one certificate of a chain.
+type CertInfo struct {
+	Subject      string    `json:"Subject"`
+	Issuer       string    `json:"Issuer"`
+	NotBefore    time.Time `json:"NotBefore"`
+	NotAfter     time.Time `json:"NotAfter"`
+	SerialNumber string    `json:"SerialNumber"`
+	SHA256       string    `json:"SHA256"`
+}
+
+// certExpiryWarning is how long before expiry a certificate is flagged.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+var tlsVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// weakCipherSuites are the TLS 1.2 and earlier suites that are offered when
+// looking for weak ones: the insecure suites, and those with RSA key
+// exchange, which lack forward secrecy.
+func weakCipherSuites() []*tls.CipherSuite {
+	weak := tls.InsecureCipherSuites()
+	for _, s := range tls.CipherSuites() {
+		if strings.HasPrefix(s.Name, "TLS_RSA_") {
+			weak = append(weak, s)
+		}
+	}
+	return weak
+}
+
+// inspectTLS connects to host:port and records its certificate chain,
+// accepted protocol versions and weak cipher suites. It returns nil when
+// nothing speaks TLS there.
+func inspectTLS(ctx context.Context, sess *scanSession, host string, port int) *TLSInfo {
+	serverName := host
+	if net.ParseIP(host) != nil {
+		serverName = ""
+	}
+	base := &tls.Config{ServerName: serverName, InsecureSkipVerify: true} // verified below, to report why it fails
+
+	state, err := tlsHandshake(ctx, sess, host, port, base)
+	if err != nil {
+		var recordErr tls.RecordHeaderError
+		var opErr *net.OpError
+		if errors.As(err, &recordErr) || (errors.As(err, &opErr) && opErr.Op == "dial") || ctx.Err() != nil {
+			return nil
+		}
+		return &TLSInfo{Error: err.Error()}
+	}
+
+	info := &TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+	inspectCertificates(info, state.PeerCertificates, host)
+
+	for _, v := range tlsVersions {
+		conf := base.Clone()
+		conf.MinVersion, conf.MaxVersion = v, v
+		if v < tls.VersionTLS13 {
+			conf.CipherSuites = allCipherSuiteIDs()
+		}
+		if _, err := tlsHandshake(ctx, sess, host, port, conf); err == nil {
+			info.Versions = append(info.Versions, tls.VersionName(v))
+		}
+	}
+
+	// Offer every weak suite at once; the server picks one it accepts, which
+	// is then removed from the offer until the server refuses all of them.
+	offer := weakCipherSuites()
+	for len(offer) > 0 && ctx.Err() == nil {
+		conf := base.Clone()
+		conf.MinVersion, conf.MaxVersion = tls.VersionTLS10, tls.VersionTLS12
+		conf.CipherSuites = nil
+		for _, s := range offer {
+			conf.CipherSuites = append(conf.CipherSuites, s.ID)
+		}
+		state, err := tlsHandshake(ctx, sess, host, port, conf)
+		if err != nil {
+			break
+		}
+		accepted := false
+		for i, s := range offer {
+			if s.ID == state.CipherSuite {
+				info.WeakCiphers = append(info.WeakCiphers, s.Name)
+				offer = append(offer[:i], offer[i+1:]...)
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			break
+		}
+	}
+	return info
+}
+
+func allCipherSuiteIDs() []uint16 {
+	var ids []uint16
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+// tlsHandshake opens a rate-limited connection and completes a handshake
+// with conf, returning the negotiated state.
+func tlsHandshake(ctx context.Context, sess *scanSession, host string, port int, conf *tls.Config) (tls.ConnectionState, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Scan.HTTPTimeout)
+	defer cancel()
+	raw, err := sess.dial(ctx, "tcp", host, port)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	conn := tls.Client(raw, conf)
+	defer conn.Close()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	return conn.ConnectionState(), nil
+}
+
+func inspectCertificates(info *TLSInfo, certs []*x509.Certificate, host string) {
+	if len(certs) == 0 {
+		return
+	}
+	leaf := certs[0]
+	info.Subject = leaf.Subject.String()
+	info.Issuer = leaf.Issuer.String()
+	info.NotBefore, info.NotAfter = leaf.NotBefore, leaf.NotAfter
+	info.SANs = append(info.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	for _, c := range certs {
+		sum := sha256.Sum256(c.Raw)
+		info.Chain = append(info.Chain, CertInfo{
+			Subject:      c.Subject.String(),
+			Issuer:       c.Issuer.String(),
+			NotBefore:    c.NotBefore,
+			NotAfter:     c.NotAfter,
+			SerialNumber: c.SerialNumber.Text(16),
+			SHA256:       hex.EncodeToString(sum[:]),
+		})
+	}
+
+	now := time.Now()
+	info.Expired = now.After(leaf.NotAfter) || now.Before(leaf.NotBefore)
+	info.SelfSigned = bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignatureFrom(leaf) == nil
+	info.HostnameMismatch = leaf.VerifyHostname(host) != nil
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	// The hostname and validity period are reported on their own, so the
+	// chain is verified at a time the leaf is valid and for any name.
+	at := now
+	if info.Expired {
+		at = leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates, CurrentTime: at}); err != nil {
+		info.VerifyError = err.Error()
+	} else {
+		info.Trusted = true
+	}
+}
+
+// reportTLS turns the problems of info into tags and findings.
+func reportTLS(result *AnalysisResult, info *TLSInfo, location string) {
+	if info.Error != "" {
+		return
+	}
+	tag := func(name string) {
+		result.Tags = append(result.Tags, Tag{Name: "TLS: " + name, Type: "tls"})
+	}
+	add := func(ruleID, title, severity, evidence, remediation string) {
+		result.addFinding(Finding{
+			Title:       title,
+			Severity:    severity,
+			Confidence:  ConfidenceHigh,
+			Evidence:    evidence,
+			Location:    location,
+			RuleID:      ruleID,
+			Remediation: remediation,
+		})
+	}
+	result.Tags = append(result.Tags, Tag{Name: info.Version, Type: "tls"})
+	validity := fmt.Sprintf("valid %s to %s", info.NotBefore.Format("2006-01-02"), info.NotAfter.Format("2006-01-02"))
+
+	switch {
+	case info.Expired:
+		tag("Expired")
+		add("tls-cert-expired", "TLS certificate expired or not yet valid", SeverityHigh, validity,
+			"Renew the certificate and automate renewal.")
+	case time.Until(info.NotAfter) < certExpiryWarning:
+		tag("Expiring")
+		add("tls-cert-expiring", "TLS certificate expires soon", SeverityLow, validity,
+			"Renew the certificate before it expires.")
+	}
+	switch {
+	case info.SelfSigned:
+		tag("Self-signed")
+		add("tls-cert-self-signed", "Self-signed TLS certificate", SeverityMedium, "issuer is the subject: "+info.Issuer,
+			"Use a certificate issued by a publicly trusted CA.")
+	case !info.Trusted:
+		tag("Untrusted")
+		add("tls-cert-untrusted", "Untrusted TLS certificate chain", SeverityMedium, info.VerifyError,
+			"Serve the complete chain of a publicly trusted CA, including intermediates.")
+	}
+	if info.HostnameMismatch {
+		tag("Hostname mismatch")
+		add("tls-hostname-mismatch", "TLS certificate does not cover the hostname", SeverityMedium, "certificate names: "+strings.Join(info.SANs, ", "),
+			"Issue a certificate whose SANs include this hostname.")
+	}
+
+	var deprecated []string
+	for _, v := range info.Versions {
+		if v == "TLS 1.0" || v == "TLS 1.1" {
+			deprecated = append(deprecated, v)
+		}
+	}
+	if len(deprecated) > 0 {
+		tag("Deprecated protocols")
+		add("tls-deprecated-protocol", "Deprecated TLS versions accepted", SeverityMedium, "accepts "+strings.Join(deprecated, ", "),
+			"Disable TLS 1.0 and 1.1; accept TLS 1.2 and 1.3 only.")
+	}
+	if len(info.WeakCiphers) > 0 {
+		severity := SeverityLow // RSA key exchange only lacks forward secrecy
+		for _, name := range info.WeakCiphers {
+			if !strings.HasPrefix(name, "TLS_RSA_") || strings.Contains(name, "RC4") || strings.Contains(name, "3DES") {
+				severity = SeverityMedium
+			}
+		}
+		tag("Weak ciphers")
+		add("tls-weak-cipher", "Weak TLS cipher suites accepted", severity, strings.Join(info.WeakCiphers, ", "),
+			"Accept only ECDHE suites with AEAD ciphers (AES-GCM, ChaCha20-Poly1305).")
+	}
+}
+
+func writeTLSReport(b *strings.Builder, info *TLSInfo) {
+	b.WriteString("\nTLS:\n")
+	if info.Error != "" {
+		fmt.Fprintf(b, "- Error: %s\n", info.Error)
+		return
+	}
+	fmt.Fprintf(b, "- Negotiated: %s, %s\n", info.Version, info.CipherSuite)
+	fmt.Fprintf(b, "- Subject: %s\n", info.Subject)
+	fmt.Fprintf(b, "- Issuer: %s\n", info.Issuer)
+	fmt.Fprintf(b, "- Valid: %s to %s\n", info.NotBefore.Format(time.RFC3339), info.NotAfter.Format(time.RFC3339))
+	if len(info.SANs) > 0 {
+		fmt.Fprintf(b, "- SANs: %s\n", strings.Join(info.SANs, ", "))
+	}
+	fmt.Fprintf(b, "- Trusted: %v", info.Trusted)
+	if info.VerifyError != "" {
+		fmt.Fprintf(b, " (%s)", info.VerifyError)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(b, "- Protocols: %s\n", strings.Join(info.Versions, ", "))
+	if len(info.WeakCiphers) > 0 {
+		fmt.Fprintf(b, "- Weak ciphers: %s\n", strings.Join(info.WeakCiphers, ", "))
+	}
+	for i, c := range info.Chain {
+		fmt.Fprintf(b, "- Chain[%d]: %s (issuer %s, SHA-256 %s)\n", i, c.Subject, c.Issuer, c.SHA256)
+	}
+}
@@ -0,0 +1,172 @@
+package modules
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed RSA certificate for 127.0.0.1 and
+// names, valid between notBefore and notAfter.
+func testCertificate(t *testing.T, notBefore, notAfter time.Time, names ...string) tls.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vuln-ai test"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		DNSNames:              names,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startTLSServer serves conf on 127.0.0.1 and returns its port.
+func startTLSServer(t *testing.T, conf *tls.Config) int {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = conf
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return serverPort(t, srv)
+}
+
+func serverPort(t *testing.T, srv *httptest.Server) int {
+	t.Helper()
+	u, _ := url.Parse(srv.URL)
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port
+}
+
+func TestInspectTLSSelfSigned(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	info := inspectTLS(context.Background(), newScanSession(1000, 0), "127.0.0.1", serverPort(t, srv))
+	if info == nil || info.Error != "" {
+		t.Fatalf("inspectTLS = %+v", info)
+	}
+	if !info.SelfSigned || info.Trusted || info.Expired || info.HostnameMismatch {
+		t.Errorf("self-signed=%v trusted=%v expired=%v mismatch=%v", info.SelfSigned, info.Trusted, info.Expired, info.HostnameMismatch)
+	}
+	if len(info.Chain) != 1 || info.Version != "TLS 1.3" {
+		t.Errorf("chain %d, version %s", len(info.Chain), info.Version)
+	}
+
+	result := &AnalysisResult{Priority: "Low"}
+	reportTLS(result, info, "127.0.0.1")
+	if !hasRule(result.Findings, "tls-cert-self-signed") {
+		t.Errorf("findings = %+v", result.Findings)
+	}
+}
+
+func TestInspectTLSHostnameMismatch(t *testing.T) {
+	now := time.Now()
+	cert := testCertificate(t, now.Add(-time.Hour), now.Add(365*24*time.Hour), "www.example.com")
+	port := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	info := inspectTLS(context.Background(), newScanSession(1000, 0), "localhost", port)
+	if info == nil || info.Error != "" {
+		t.Fatalf("inspectTLS = %+v", info)
+	}
+	if !info.HostnameMismatch {
+		t.Error("hostname mismatch not detected")
+	}
+	if !reflect.DeepEqual(info.SANs, []string{"www.example.com", "127.0.0.1"}) {
+		t.Errorf("SANs = %v", info.SANs)
+	}
+}
+
+func TestInspectTLSExpired(t *testing.T) {
+	now := time.Now()
+	cert := testCertificate(t, now.Add(-60*24*time.Hour), now.Add(-24*time.Hour))
+	port := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	info := inspectTLS(context.Background(), newScanSession(1000, 0), "127.0.0.1", port)
+	if info == nil || info.Error != "" {
+		t.Fatalf("inspectTLS = %+v", info)
+	}
+	if !info.Expired || info.HostnameMismatch {
+		t.Errorf("expired=%v mismatch=%v", info.Expired, info.HostnameMismatch)
+	}
+
+	result := &AnalysisResult{Priority: "Low"}
+	reportTLS(result, info, "127.0.0.1")
+	if !hasRule(result.Findings, "tls-cert-expired") || result.Priority != "High" {
+		t.Errorf("priority %s, findings %+v", result.Priority, result.Findings)
+	}
+}
+
+func TestInspectTLSWeakCiphers(t *testing.T) {
+	now := time.Now()
+	cert := testCertificate(t, now.Add(-time.Hour), now.Add(365*24*time.Hour))
+	port := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		},
+	})
+
+	info := inspectTLS(context.Background(), newScanSession(1000, 0), "127.0.0.1", port)
+	if info == nil || info.Error != "" {
+		t.Fatalf("inspectTLS = %+v", info)
+	}
+	weak := append([]string(nil), info.WeakCiphers...)
+	sort.Strings(weak)
+	if want := []string{"TLS_RSA_WITH_AES_128_CBC_SHA", "TLS_RSA_WITH_AES_256_GCM_SHA384"}; !reflect.DeepEqual(weak, want) {
+		t.Errorf("weak ciphers = %v, want %v", weak, want)
+	}
+	if !reflect.DeepEqual(info.Versions, []string{"TLS 1.2"}) {
+		t.Errorf("versions = %v", info.Versions)
+	}
+}
+
+func TestInspectTLSPlainHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	if info := inspectTLS(context.Background(), newScanSession(1000, 0), "127.0.0.1", serverPort(t, srv)); info != nil {
+		t.Errorf("inspectTLS on plain HTTP = %+v, want nil", info)
+	}
+}
+
+func hasRule(findings []Finding, ruleID string) bool {
+	for _, f := range findings {
+		if f.RuleID == ruleID {
+			return true
+		}
+	}
+	return false
+}
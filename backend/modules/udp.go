@@ -0,0 +1,266 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// udpTimeout is how long a UDP probe waits for a reply before it is sent
+// again, udpAttempts times in all, since either datagram may be lost.
+const (
+	udpTimeout  = 2 * time.Second
+	udpAttempts = 2
+)
+
+// udpProbe is a request a UDP service answers, and the parser that turns the
+// answer into a port result. parse reports whether the reply is from the
+// expected service.
+type udpProbe struct {
+	Port    int
+	Service string
+	Payload func() []byte
+	Parse   func(res *PortResult, reply []byte) bool
+}
+
+// udpProbes are sent when a port scan includes UDP. Unlike TCP there is no
+// connection to detect, so only services that answer a probe are reported.
+var udpProbes = []udpProbe{
+	{Port: 53, Service: "domain", Payload: dnsVersionQuery, Parse: parseDNSReply},
+	{Port: 69, Service: "tftp", Payload: tftpReadRequest, Parse: parseTFTPReply},
+	{Port: 123, Service: "ntp", Payload: ntpClientRequest, Parse: parseNTPReply},
+	{Port: 161, Service: "snmp", Payload: snmpGetSysDescr, Parse: parseSNMPReply},
+	{Port: 1900, Service: "ssdp", Payload: ssdpSearch, Parse: parseSSDPReply},
+	{Port: 11211, Service: "memcached", Payload: memcachedVersion, Parse: parseMemcachedReply},
+}
+
+// scanUDPPorts sends each UDP probe to subdomain and returns the services
+// that answered.
+func scanUDPPorts(ctx context.Context, sess *scanSession, subdomain string) []PortResult {
+	host := hostOnly(subdomain)
+	var mu sync.Mutex
+	var open []PortResult
+	var wg sync.WaitGroup
+	for _, probe := range udpProbes {
+		wg.Add(1)
+		go func(probe udpProbe) {
+			defer wg.Done()
+			reply := udpExchange(ctx, sess, host, probe.Port, probe.Payload())
+			if reply == nil {
+				return
+			}
+			res := PortResult{Port: probe.Port, Protocol: "udp", Service: probe.Service}
+			if !probe.Parse(&res, reply) {
+				return
+			}
+			mu.Lock()
+			open = append(open, res)
+			mu.Unlock()
+		}(probe)
+	}
+	wg.Wait()
+	sort.Slice(open, func(i, j int) bool { return open[i].Port < open[j].Port })
+	return open
+}
+
+// udpExchange sends payload to host:port and returns the first datagram that
+// comes back from the host. Replies from any port count: TFTP servers answer
+// from a new one.
+func udpExchange(ctx context.Context, sess *scanSession, host string, port int, payload []byte) []byte {
+	if err := sess.limiter.Wait(ctx, host); err != nil {
+		return nil
+	}
+	ips, err := sess.resolver().LookupIP(ctx, "ip", host)
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	target := &net.UDPAddr{IP: ips[0], Port: port}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	// Closing the socket ends a read that would otherwise wait out
+	// udpTimeout after the job is cancelled.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	buf := make([]byte, maxBannerSize)
+	for attempt := 0; attempt < udpAttempts && ctx.Err() == nil; attempt++ {
+		if _, err := conn.WriteTo(payload, target); err != nil {
+			return nil
+		}
+		conn.SetReadDeadline(time.Now().Add(udpTimeout))
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				break // timed out, send again
+			}
+			if addr, ok := from.(*net.UDPAddr); ok && addr.IP.Equal(target.IP) {
+				return append([]byte(nil), buf[:n]...)
+			}
+		}
+	}
+	return nil
+}
+
+// dnsQueryID identifies the version.bind query in replies.
+const dnsQueryID = 0x5641
+
+func dnsVersionQuery() []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: dnsQueryID})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("version.bind."),
+		Type:  dnsmessage.TypeTXT,
+		Class: dnsmessage.ClassCHAOS,
+	})
+	msg, _ := b.Finish()
+	return msg
+}
+
+func parseDNSReply(res *PortResult, reply []byte) bool {
+	var p dnsmessage.Parser
+	h, err := p.Start(reply)
+	if err != nil || !h.Response || h.ID != dnsQueryID {
+		return false
+	}
+	res.Banner = "DNS server, " + strings.TrimPrefix(h.RCode.String(), "RCode")
+	if p.SkipAllQuestions() != nil {
+		return true
+	}
+	for {
+		rh, err := p.AnswerHeader()
+		if err != nil {
+			return true
+		}
+		if rh.Type != dnsmessage.TypeTXT {
+			p.SkipAnswer()
+			continue
+		}
+		txt, err := p.TXTResource()
+		if err != nil {
+			return true
+		}
+		res.Version = strings.Join(txt.TXT, " ")
+		res.Banner = "version.bind: " + res.Version
+		return true
+	}
+}
+
+func tftpReadRequest() []byte {
+	// A file that does not exist: the error reply shows the server runs
+	// without reading anything from it.
+	name := make([]byte, 8)
+	rand.Read(name)
+	return []byte(fmt.Sprintf("\x00\x01vuln-ai-%x\x00octet\x00", name))
+}
+
+func parseTFTPReply(res *PortResult, reply []byte) bool {
+	if len(reply) < 4 || reply[0] != 0 {
+		return false
+	}
+	switch reply[1] {
+	case 3: // DATA
+		res.Banner = "TFTP server, serves files to anonymous requests"
+	case 5: // ERROR
+		msg, _, _ := strings.Cut(string(reply[4:]), "\x00")
+		res.Banner = fmt.Sprintf("TFTP error %d: %s", binary.BigEndian.Uint16(reply[2:4]), printableBanner([]byte(msg)))
+	default:
+		return false
+	}
+	return true
+}
+
+func ntpClientRequest() []byte {
+	req := make([]byte, 48)
+	req[0] = 0x1b // version 3, client mode
+	return req
+}
+
+func parseNTPReply(res *PortResult, reply []byte) bool {
+	if len(reply) < 48 || reply[0]&0x07 != 4 { // server mode
+		return false
+	}
+	version, stratum := (reply[0]>>3)&0x07, reply[1]
+	res.Version = fmt.Sprintf("NTPv%d", version)
+	res.Banner = fmt.Sprintf("NTPv%d server, stratum %d", version, stratum)
+	if stratum == 1 { // the reference ID names the clock source
+		res.Banner += ", reference " + printableBanner(bytes.TrimRight(reply[12:16], "\x00"))
+	}
+	return true
+}
+
+// sysDescrOID is the BER encoding of 1.3.6.1.2.1.1.1.0.
+var sysDescrOID = []byte{0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00}
+
+// snmpGetSysDescr is an SNMPv2c GetRequest for sysDescr.0 with the public
+// community.
+func snmpGetSysDescr() []byte {
+	varbind := append(append([]byte{0x30, 0x0c, 0x06, 0x08}, sysDescrOID...), 0x05, 0x00)
+	pdu := append([]byte{0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x0e}, varbind...)
+	msg := append([]byte{0x30, 0x26, 0x02, 0x01, 0x01, 0x04, 0x06}, "public"...)
+	return append(msg, pdu...)
+}
+
+func parseSNMPReply(res *PortResult, reply []byte) bool {
+	if len(reply) < 2 || reply[0] != 0x30 {
+		return false
+	}
+	res.Version = "v2c"
+	res.Banner = "SNMP agent answers the public community"
+	i := bytes.Index(reply, sysDescrOID)
+	if i < 0 || i+len(sysDescrOID)+2 > len(reply) || reply[i+len(sysDescrOID)] != 0x04 {
+		return true
+	}
+	value := reply[i+len(sysDescrOID)+1:]
+	n := int(value[0])
+	value = value[1:]
+	if n == 0x81 && len(value) > 0 { // long form length
+		n, value = int(value[0]), value[1:]
+	}
+	if n > len(value) {
+		n = len(value)
+	}
+	if n > 0 {
+		res.Banner = "sysDescr: " + printableBanner(value[:n])
+	}
+	return true
+}
+
+func ssdpSearch() []byte {
+	return []byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n")
+}
+
+func parseSSDPReply(res *PortResult, reply []byte) bool {
+	if !bytes.HasPrefix(reply, []byte("HTTP/1.")) {
+		return false
+	}
+	res.Banner = printableBanner(reply)
+	if server, err := readHTTPHead(reply); err == nil && server != "" {
+		res.Product = server
+	}
+	return true
+}
+
+func memcachedVersion() []byte {
+	// UDP frame header: request id, sequence number, datagram count, reserved.
+	return append([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}, "version\r\n"...)
+}
+
+func parseMemcachedReply(res *PortResult, reply []byte) bool {
+	if len(reply) < 8 || !bytes.HasPrefix(reply[8:], []byte("VERSION ")) {
+		return false
+	}
+	fingerprintService(res, reply[8:])
+	return true
+}
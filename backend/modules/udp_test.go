@@ -0,0 +1,193 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseDNSReply(t *testing.T) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(dnsVersionQuery()); err != nil {
+		t.Fatal(err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: dnsQueryID, Response: true})
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	b.TXTResource(dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class}, dnsmessage.TXTResource{TXT: []string{"9.18.1-Ubuntu"}})
+	reply, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res PortResult
+	if !parseDNSReply(&res, reply) {
+		t.Fatal("reply not recognised")
+	}
+	if res.Version != "9.18.1-Ubuntu" || res.Banner != "version.bind: 9.18.1-Ubuntu" {
+		t.Errorf("result = %+v", res)
+	}
+
+	refused := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: dnsQueryID, Response: true, RCode: dnsmessage.RCodeRefused})
+	reply, _ = refused.Finish()
+	res = PortResult{}
+	if !parseDNSReply(&res, reply) || res.Banner != "DNS server, Refused" {
+		t.Errorf("refused result = %+v", res)
+	}
+
+	other := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, Response: true})
+	reply, _ = other.Finish()
+	if parseDNSReply(&PortResult{}, reply) {
+		t.Error("reply with another ID accepted")
+	}
+}
+
+func TestParseNTPReply(t *testing.T) {
+	reply := make([]byte, 48)
+	reply[0] = 0x24 // version 4, server mode
+	reply[1] = 1
+	copy(reply[12:], "GPS")
+	var res PortResult
+	if !parseNTPReply(&res, reply) {
+		t.Fatal("reply not recognised")
+	}
+	if res.Version != "NTPv4" || res.Banner != "NTPv4 server, stratum 1, reference GPS" {
+		t.Errorf("result = %+v", res)
+	}
+	if parseNTPReply(&PortResult{}, ntpClientRequest()) {
+		t.Error("client-mode packet accepted")
+	}
+}
+
+func TestParseSNMPReply(t *testing.T) {
+	descr := "Linux router 5.4.0"
+	reply := append([]byte{0x30, 0x40, 0x02, 0x01, 0x01, 0x04, 0x06}, "public"...)
+	reply = append(reply, 0xa2, 0x30, 0x30, 0x20, 0x06, 0x08)
+	reply = append(reply, sysDescrOID...)
+	reply = append(reply, 0x04, byte(len(descr)))
+	reply = append(reply, descr...)
+
+	var res PortResult
+	if !parseSNMPReply(&res, reply) {
+		t.Fatal("reply not recognised")
+	}
+	if res.Version != "v2c" || res.Banner != "sysDescr: "+descr {
+		t.Errorf("result = %+v", res)
+	}
+	if parseSNMPReply(&PortResult{}, []byte("HTTP/1.1 200 OK")) {
+		t.Error("non-SNMP reply accepted")
+	}
+}
+
+func TestParseTFTPReply(t *testing.T) {
+	var res PortResult
+	if !parseTFTPReply(&res, []byte("\x00\x05\x00\x01File not found\x00")) {
+		t.Fatal("error reply not recognised")
+	}
+	if res.Banner != "TFTP error 1: File not found" {
+		t.Errorf("banner = %q", res.Banner)
+	}
+	res = PortResult{}
+	if !parseTFTPReply(&res, []byte("\x00\x03\x00\x01data")) || res.Banner == "" {
+		t.Errorf("data reply result = %+v", res)
+	}
+	if parseTFTPReply(&PortResult{}, []byte("\x00\x01x")) {
+		t.Error("short reply accepted")
+	}
+}
+
+func TestParseSSDPReply(t *testing.T) {
+	reply := []byte("HTTP/1.1 200 OK\r\nSERVER: Linux/3.14 UPnP/1.0 miniupnpd/2.1\r\nST: upnp:rootdevice\r\n\r\n")
+	var res PortResult
+	if !parseSSDPReply(&res, reply) {
+		t.Fatal("reply not recognised")
+	}
+	if res.Product != "Linux/3.14 UPnP/1.0 miniupnpd/2.1" {
+		t.Errorf("product = %q", res.Product)
+	}
+	if parseSSDPReply(&PortResult{}, []byte("NOTIFY * HTTP/1.1\r\n\r\n")) {
+		t.Error("request accepted as a reply")
+	}
+}
+
+func TestParseMemcachedReply(t *testing.T) {
+	reply := append(memcachedVersion()[:8], "VERSION 1.6.21\r\n"...)
+	var res PortResult
+	if !parseMemcachedReply(&res, reply) {
+		t.Fatal("reply not recognised")
+	}
+	if res.Version != "1.6.21" {
+		t.Errorf("result = %+v", res)
+	}
+	if parseMemcachedReply(&PortResult{}, []byte("VERSION 1.6.21\r\n")) {
+		t.Error("reply without frame header accepted")
+	}
+}
+
+// udpListener answers every datagram with reply, from a new socket if
+// fromNewPort is set, as TFTP servers do.
+func udpListener(t *testing.T, reply []byte, fromNewPort bool) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			_, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply == nil {
+				continue
+			}
+			out := conn
+			if fromNewPort {
+				if out, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+					return
+				}
+			}
+			out.WriteTo(reply, from)
+			if fromNewPort {
+				out.Close()
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUDPExchange(t *testing.T) {
+	sess := newScanSession(1000, 0)
+	want := []byte("\x00\x05\x00\x01File not found\x00")
+	for _, newPort := range []bool{false, true} {
+		port := udpListener(t, want, newPort)
+		if got := udpExchange(context.Background(), sess, "127.0.0.1", port, tftpReadRequest()); !bytes.Equal(got, want) {
+			t.Errorf("reply (from new port %v) = %q, want %q", newPort, got, want)
+		}
+	}
+}
+
+func TestUDPExchangeCancelled(t *testing.T) {
+	port := udpListener(t, nil, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if got := udpExchange(ctx, newScanSession(1000, 0), "127.0.0.1", port, ntpClientRequest()); got != nil {
+		t.Errorf("reply = %q, want none", got)
+	}
+	if elapsed := time.Since(start); elapsed > udpTimeout {
+		t.Errorf("returned after %s, want soon after the context ended", elapsed)
+	}
+}
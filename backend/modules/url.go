@@ -2,33 +2,38 @@ package modules
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
-	"regexp"
 	"sort"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 type URLAnalysisResult struct {
-	URL           string   `json:"URL"`
-	IsReachable   bool     `json:"IsReachable"`
-	StatusCode    int      `json:"StatusCode"`
-	ContentLength int64    `json:"ContentLength"`
-	Priority      string   `json:"Priority"`
-	Findings      []string `json:"Findings"`
-	Headers       string   `json:"Headers"`
+	URL             string      `json:"URL"`
+	IsReachable     bool        `json:"IsReachable"`
+	StatusCode      int         `json:"StatusCode"`
+	ContentLength   int64       `json:"ContentLength"`
+	Priority        string      `json:"Priority"`
+	Findings        []Finding   `json:"Findings"` // most severe first
+	Headers         string      `json:"Headers"`
+	ResponseHeaders http.Header `json:"ResponseHeaders"`
 }
 type URLAnalysisRequest struct {
-	URLs       []string `form:"urls[]"`
-	AIProvider string   `form:"aiProvider"`
-	APIKey     string   `form:"apiKey"`
+	URLs              []string `form:"urls[]"`
+	AIProvider        string   `form:"aiProvider"`
+	APIKey            string   `form:"apiKey"`
+	RequestsPerSecond string   `form:"requestsPerSecond"`
+	Concurrency       string   `form:"concurrency"`
+	Timeout           string   `form:"timeout"` // optional job deadline in seconds
+	Notify            string   `form:"notify"`  // JSON list of webhook, slack, discord or email sinks for this job
+
+	NotifySinks []NotifierConfig `form:"-"` // parsed from Notify
 }
 
 func HandleURLAnalysis(c *gin.Context) {
@@ -59,36 +64,93 @@ func HandleURLAnalysis(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No URLs provided"})
 		return
 	}
+	if req.NotifySinks, err = parseJobSinks(req.Notify); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := createJob(JobKindURL, currentUser(c).Username, req.URLs, map[string]string{
+		"aiProvider":        req.AIProvider,
+		"requestsPerSecond": req.RequestsPerSecond,
+		"concurrency":       req.Concurrency,
+		"timeout":           req.Timeout,
+	})
+	ctx := startJobRuntime(job.ID, parseJobTimeout(req.Timeout))
+	go performURLAnalysis(ctx, req, job.ID)
+	c.JSON(http.StatusOK, gin.H{"jobID": job.ID})
+}
+
+func performURLAnalysis(ctx context.Context, req URLAnalysisRequest, jobID string) {
+	defer finishJobRuntime(jobID)
+
+	total := len(req.URLs)
+	BroadcastJobStarted(jobID)
+	finalResults := runURLAnalysis(ctx, req, jobID, func(processed, total int, message string) {
+		setJobProcessed(jobID, processed)
+	})
+	processed := len(finalResults)
+
+	StoreURLResults(jobID, finalResults)
+	var findings []Finding
+	for _, r := range finalResults {
+		findings = append(findings, r.Findings...)
+	}
+	status := jobEndStatus(ctx)
+	defer notifyJobEnd(jobID, JobKindURL, status, req.NotifySinks, findings)
+	if status != JobStatusCompleted {
+		finishJob(jobID, status, processed)
+		BroadcastJobEnd(jobID, percent(processed, total), status)
+		return
+	}
+	finishJob(jobID, JobStatusCompleted, processed)
+	BroadcastJobEnd(jobID, 100, JobStatusCompleted)
+}
 
-	jobID := uuid.New().String()
-	go performURLAnalysis(req, jobID)
-	c.JSON(http.StatusOK, gin.H{"jobID": jobID})
+// RunURLAnalysis analyses req.URLs without registering a job.
+func RunURLAnalysis(ctx context.Context, req URLAnalysisRequest, progress ProgressFunc) []URLAnalysisResult {
+	return runURLAnalysis(ctx, req, "", progress)
 }
 
-func performURLAnalysis(req URLAnalysisRequest, jobID string) {
+func runURLAnalysis(ctx context.Context, req URLAnalysisRequest, jobID string, progress ProgressFunc) []URLAnalysisResult {
 	total := len(req.URLs)
 	var processed int
 	var mu sync.Mutex
 	var finalResults []URLAnalysisResult
 	var wg sync.WaitGroup
-	guard := make(chan struct{}, 10)
+	sess := newScanSession(parsePositiveFloat(req.RequestsPerSecond, cfg.Scan.RequestsPerSecond), cfg.Scan.PerHostRPS)
+	defer sess.close()
+	guard := make(chan struct{}, parsePositiveInt(req.Concurrency, cfg.Scan.Concurrency))
 
+dispatch:
 	for _, u := range req.URLs {
+		select {
+		case guard <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
 		wg.Add(1)
-		guard <- struct{}{}
 		go func(targetURL string) {
 			defer wg.Done()
 			defer func() { <-guard }()
-			for GetJobState(jobID) == "paused" {
-				time.Sleep(500 * time.Millisecond)
+			if waitIfPaused(ctx, jobID) != nil {
+				return
+			}
+			mu.Lock()
+			started := percent(processed, total)
+			mu.Unlock()
+			BroadcastHostStarted(jobID, started, targetURL)
+			result := analyzeSingleURL(ctx, sess, targetURL)
+			if ctx.Err() != nil {
+				return // aborted mid-request, the result is incomplete
 			}
-			result := analyzeSingleURL(targetURL)
 			mu.Lock()
 			processed++
 			finalResults = append(finalResults, result)
-			progress := (processed * 100) / total
-			BroadcastProgress(jobID, progress, fmt.Sprintf("Analyzing %d/%d: %s", processed, total, targetURL))
+			message := fmt.Sprintf("Analyzing %d/%d: %s", processed, total, targetURL)
+			done := percent(processed, total)
+			progress(processed, total, message)
 			mu.Unlock()
+			BroadcastHostResult(jobID, done, message, targetURL, result, result.Findings)
 		}(u)
 	}
 	wg.Wait()
@@ -100,14 +162,18 @@ func performURLAnalysis(req URLAnalysisRequest, jobID string) {
 		priorityOrder := map[string]int{"High": 0, "Medium": 1, "Low": 2}
 		return priorityOrder[finalResults[i].Priority] < priorityOrder[finalResults[j].Priority]
 	})
-	BroadcastFinalResults(jobID, finalResults)
+	return finalResults
 }
 
-func analyzeSingleURL(targetURL string) URLAnalysisResult {
+func analyzeSingleURL(ctx context.Context, sess *scanSession, targetURL string) URLAnalysisResult {
 	result := URLAnalysisResult{URL: targetURL, Priority: "Low"}
-	findingsSet := make(map[string]bool)
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(targetURL)
+	client := sess.client
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		result.IsReachable = false
+		return result
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		result.IsReachable = false
 		return result
@@ -124,55 +190,67 @@ func analyzeSingleURL(targetURL string) URLAnalysisResult {
 		}
 	}
 	result.Headers = headers.String()
+	result.ResponseHeaders = resp.Header
+	result.Findings = auditHeaders(ctx, sess, resp)
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, maxPageBody))
 	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	page := resp.Request.URL.String()
 	if server := resp.Header.Get("Server"); server != "" {
-		findingsSet[fmt.Sprintf("Header - Server: %s", server)] = true
+		result.Findings = appendFinding(result.Findings, Finding{
+			Title:       "Server header discloses software",
+			Severity:    SeverityInfo,
+			Confidence:  ConfidenceHigh,
+			Evidence:    "Server: " + server,
+			Location:    page,
+			RuleID:      "server-header",
+			Remediation: "Remove the Server header or reduce it to a generic value.",
+		})
 	}
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(bodyBytes))
 	if err == nil {
 		sensitiveKeywords := []string{"api", "admin", "login", "dashboard", "config", "token", "password", "jwt"}
 		doc.Find("a[href], script[src]").Each(func(i int, s *goquery.Selection) {
+			attr := "href"
 			path, _ := s.Attr("href")
 			if src, exists := s.Attr("src"); exists {
-				path = src
+				attr, path = "src", src
 			}
 			if absURL, err := resp.Request.URL.Parse(path); err == nil {
 				for _, keyword := range sensitiveKeywords {
 					if strings.Contains(strings.ToLower(absURL.String()), keyword) {
-						findingsSet[fmt.Sprintf("Sensitive Link: %s", absURL.String())] = true
+						result.Findings = appendFinding(result.Findings, Finding{
+							Title:      "Sensitive link",
+							Severity:   SeverityMedium,
+							Confidence: ConfidenceLow,
+							Evidence:   fmt.Sprintf("%s=%q on %s", attr, path, page),
+							Location:   absURL.String(),
+							RuleID:     "sensitive-link",
+						})
 					}
 				}
 			}
 		})
 		if doc.Find("form[action*='login']").Length() > 0 || doc.Find("input[type='password']").Length() > 0 {
-			findingsSet["Functionality: Login form detected"] = true
+			result.Findings = appendFinding(result.Findings, Finding{
+				Title:       "Login form",
+				Severity:    SeverityMedium,
+				Confidence:  ConfidenceHigh,
+				Evidence:    "form with a login action or a password field",
+				Location:    page,
+				RuleID:      "login-form",
+				Remediation: "Make sure the form is served over HTTPS and protected against brute force.",
+			})
 		}
 	}
-	apiKeyRegex := regexp.MustCompile(`(?i)['"](api_key|secret_key|token)['"]\s*[:=]\s*['"]([a-zA-Z0-9\-_]{20,})['"]`)
-	if apiKeyRegex.Match(bodyBytes) {
-		findingsSet["HIGH: Potential API key found in response body"] = true
-	}
-	for finding := range findingsSet {
-		result.Findings = append(result.Findings, finding)
-	}
-	sort.Strings(result.Findings)
-	if containsFinding(result.Findings, "HIGH:") {
-		result.Priority = "High"
-	} else if containsFinding(result.Findings, "Sensitive Link") || containsFinding(result.Findings, "Login form") {
-		result.Priority = "Medium"
+	for _, s := range sess.secrets.Scan(page, bodyBytes) {
+		result.Findings = appendFinding(result.Findings, secretFinding(s))
 	}
+	sortFindings(result.Findings)
+	result.Priority = findingsPriority(result.Findings)
 	return result
 }
-func containsFinding(findings []string, substr string) bool {
-	for _, f := range findings {
-		if strings.Contains(f, substr) {
-			return true
-		}
-	}
-	return false
-}
+
 func parseURLsFromText(text string) []string {
 	var urls []string
 	for _, line := range strings.Split(text, "\n") {